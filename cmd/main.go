@@ -1,12 +1,25 @@
 package main
 
 import (
+	"flag"
 	"log"
+	"os"
 
 	"murmapp.hook/internal"
+	"murmapp.hook/internal/config"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "rotate-token" {
+		if err := internal.RotateToken(os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		return
+	}
+
+	flag.StringVar(&config.ConfigFile, "config", "", "YAML config file (default $CONFIG_FILE); environment variables override it")
+	flag.Parse()
+
 	if err := internal.Run(); err != nil {
 		log.Fatalf("❌ fatal error: %v", err)
 	}
@@ -3,18 +3,26 @@ module murmapp.hook
 go 1.24.1
 
 require (
-	github.com/eugene-ruby/xconnect v0.3.3
 	github.com/eugene-ruby/xencryptor v0.2.3
 	github.com/go-chi/chi/v5 v5.0.8
+	github.com/klauspost/compress v1.18.0
+	github.com/nats-io/nats.go v1.41.2
+	github.com/segmentio/kafka-go v0.4.47
 	github.com/streadway/amqp v1.1.0
 	github.com/stretchr/testify v1.10.0
+	go.etcd.io/bbolt v1.4.3
 	google.golang.org/protobuf v1.33.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/nats-io/nkeys v0.4.11 // indirect
+	github.com/nats-io/nuid v1.0.1 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	golang.org/x/crypto v0.37.0 // indirect
+	golang.org/x/sys v0.32.0 // indirect
 )
 
 replace murmapp.hook/proto => ./proto
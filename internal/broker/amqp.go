@@ -0,0 +1,300 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// maxInFlight caps the publishes awaiting a broker confirm. The confirm
+// channel is as large, so the library never blocks delivering one.
+const maxInFlight = 256
+
+// AMQPPublisher publishes to a RabbitMQ exchange with publisher confirms enabled.
+type AMQPPublisher struct {
+	conn     *amqp.Connection
+	ch       *amqp.Channel
+	exchange string
+
+	// mu orders publishes with their delivery tags.
+	mu      sync.Mutex
+	nextTag uint64
+	// inFlight holds a slot per publish until its confirm arrives, even
+	// when the caller gave up waiting.
+	inFlight chan struct{}
+
+	pendingMu sync.Mutex
+	// pending maps delivery tags to the caller waiting for their confirm;
+	// confirmLoop drains it.
+	pending map[uint64]chan amqp.Confirmation
+	closed  bool
+
+	declareMu sync.Mutex
+	// declared holds override exchanges declared by PublishExchange;
+	// publishing to a missing exchange would close the channel.
+	declared map[string]bool
+}
+
+// DialAMQP connects to RabbitMQ and puts the channel into confirm mode.
+func DialAMQP(rawURL, exchange string) (*AMQPPublisher, error) {
+	conn, err := amqp.Dial(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		_ = ch.Close()
+		_ = conn.Close()
+		return nil, fmt.Errorf("enable publisher confirms: %w", err)
+	}
+
+	p := &AMQPPublisher{
+		conn:     conn,
+		ch:       ch,
+		exchange: exchange,
+		inFlight: make(chan struct{}, maxInFlight),
+		pending:  map[uint64]chan amqp.Confirmation{},
+	}
+	go p.confirmLoop(ch.NotifyPublish(make(chan amqp.Confirmation, maxInFlight)))
+	return p, nil
+}
+
+// confirmLoop hands each confirm to the publish waiting for its tag. Once
+// the channel closes, publishes still waiting fail.
+func (p *AMQPPublisher) confirmLoop(confirms <-chan amqp.Confirmation) {
+	for c := range confirms {
+		p.pendingMu.Lock()
+		waiter, ok := p.pending[c.DeliveryTag]
+		delete(p.pending, c.DeliveryTag)
+		p.pendingMu.Unlock()
+		if ok {
+			waiter <- c
+			<-p.inFlight
+		}
+	}
+
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+	p.closed = true
+	for tag, waiter := range p.pending {
+		close(waiter)
+		delete(p.pending, tag)
+		<-p.inFlight
+	}
+}
+
+// Channel exposes the raw AMQP channel, e.g. for declaring the topology.
+func (p *AMQPPublisher) Channel() *amqp.Channel {
+	return p.ch
+}
+
+// Publish sends body to the exchange with topic as routing key and waits for the broker confirm.
+func (p *AMQPPublisher) Publish(ctx context.Context, topic string, headers map[string]string, body []byte) error {
+	return p.publish(ctx, p.exchange, topic, headers, body)
+}
+
+// PublishExchange is Publish to another exchange. The exchange is declared
+// as a durable topic exchange on first use.
+func (p *AMQPPublisher) PublishExchange(ctx context.Context, exchange, topic string, headers map[string]string, body []byte) error {
+	if err := p.declare(exchange); err != nil {
+		return err
+	}
+	return p.publish(ctx, exchange, topic, headers, body)
+}
+
+// declare declares exchange once, on a channel of its own: a failed
+// declaration, e.g. PRECONDITION_FAILED for an existing exchange of another
+// type, closes the channel it ran on, and that must not be the one every
+// bot publishes on.
+func (p *AMQPPublisher) declare(exchange string) error {
+	p.declareMu.Lock()
+	defer p.declareMu.Unlock()
+	if p.declared[exchange] {
+		return nil
+	}
+
+	ch, err := p.conn.Channel()
+	if err != nil {
+		return fmt.Errorf("declare exchange %s: %w", exchange, err)
+	}
+	defer ch.Close()
+	if err := ch.ExchangeDeclare(exchange, "topic", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("declare exchange %s: %w", exchange, err)
+	}
+	if p.declared == nil {
+		p.declared = map[string]bool{}
+	}
+	p.declared[exchange] = true
+	return nil
+}
+
+// publish sends one message and waits for its confirm. Only the send itself
+// is serialised, so other publishes go out while this one is unconfirmed.
+func (p *AMQPPublisher) publish(ctx context.Context, exchange, topic string, headers map[string]string, body []byte) error {
+	select {
+	case p.inFlight <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	waiter, err := p.send(exchange, topic, toPublishing(headers, body))
+	if err != nil {
+		return err
+	}
+
+	select {
+	case c, ok := <-waiter:
+		if !ok {
+			return fmt.Errorf("amqp channel closed before confirm")
+		}
+		if !c.Ack {
+			return fmt.Errorf("broker nacked message on %s", topic)
+		}
+		return nil
+	case <-ctx.Done():
+		// confirmLoop still takes the confirm and frees the slot.
+		return ctx.Err()
+	}
+}
+
+// send publishes msg and registers a waiter for the delivery tag the
+// channel gives it, which is the next one only if the send succeeds. A
+// failed send frees its in-flight slot unless confirmLoop already did.
+func (p *AMQPPublisher) send(exchange, topic string, msg amqp.Publishing) (chan amqp.Confirmation, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tag := p.nextTag + 1
+	waiter := make(chan amqp.Confirmation, 1)
+	p.pendingMu.Lock()
+	if p.closed {
+		p.pendingMu.Unlock()
+		<-p.inFlight
+		return nil, fmt.Errorf("amqp channel closed")
+	}
+	p.pending[tag] = waiter
+	p.pendingMu.Unlock()
+
+	if err := p.ch.Publish(exchange, topic, false, false, msg); err != nil {
+		p.pendingMu.Lock()
+		if _, ok := p.pending[tag]; ok {
+			delete(p.pending, tag)
+			<-p.inFlight
+		}
+		p.pendingMu.Unlock()
+		return nil, err
+	}
+	p.nextTag = tag
+	return waiter, nil
+}
+
+// Consume reads queue on a dedicated channel and acks each delivery once
+// handle returns nil. A delivery handle asks to retry is requeued after the
+// delay; with a prefetch of one nothing else is consumed meanwhile.
+func (p *AMQPPublisher) Consume(ctx context.Context, queue string, handle func(context.Context, Delivery) error) error {
+	ch, err := p.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if err := ch.Qos(1, 0, false); err != nil {
+		return fmt.Errorf("set prefetch on %s: %w", queue, err)
+	}
+	deliveries, err := ch.Consume(queue, "", false, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("consume %s: %w", queue, err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case d, ok := <-deliveries:
+			if !ok {
+				return fmt.Errorf("amqp channel closed while consuming %s", queue)
+			}
+			if err := handle(ctx, fromDelivery(d)); err != nil {
+				delay, retry := retryDelay(err)
+				if retry {
+					select {
+					case <-time.After(delay):
+					case <-ctx.Done():
+					}
+				}
+				_ = d.Nack(false, retry)
+				continue
+			}
+			_ = d.Ack(false)
+		}
+	}
+}
+
+// Close gracefully shuts down the AMQP connection and channel.
+func (p *AMQPPublisher) Close() error {
+	if p.ch != nil {
+		_ = p.ch.Close()
+	}
+	if p.conn != nil {
+		return p.conn.Close()
+	}
+	return nil
+}
+
+// toPublishing moves well-known headers into AMQP message properties and
+// keeps the rest as application headers. Messages are always persistent.
+func toPublishing(headers map[string]string, body []byte) amqp.Publishing {
+	msg := amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		AppId:        AppID,
+		Body:         body,
+	}
+
+	for k, v := range headers {
+		switch k {
+		case HeaderContentType:
+			msg.ContentType = v
+		case HeaderMessageID:
+			msg.MessageId = v
+		case HeaderType:
+			msg.Type = v
+		default:
+			if msg.Headers == nil {
+				msg.Headers = amqp.Table{}
+			}
+			msg.Headers[k] = v
+		}
+	}
+	return msg
+}
+
+// fromDelivery is the inverse of toPublishing: well-known properties become
+// headers next to the string-valued application headers.
+func fromDelivery(d amqp.Delivery) Delivery {
+	headers := map[string]string{}
+	for k, v := range d.Headers {
+		if s, ok := v.(string); ok {
+			headers[k] = s
+		}
+	}
+	if d.ContentType != "" {
+		headers[HeaderContentType] = d.ContentType
+	}
+	if d.MessageId != "" {
+		headers[HeaderMessageID] = d.MessageId
+	}
+	if d.Type != "" {
+		headers[HeaderType] = d.Type
+	}
+	return Delivery{Headers: headers, Body: d.Body}
+}
@@ -0,0 +1,79 @@
+package broker
+
+import (
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToPublishing(t *testing.T) {
+	msg := toPublishing(map[string]string{
+		HeaderContentType:  "application/x-protobuf",
+		HeaderMessageID:    "abc",
+		HeaderType:         "hook.TelegramWebhookPayload",
+		"x-schema-version": "1",
+	}, []byte("body"))
+
+	require.Equal(t, "application/x-protobuf", msg.ContentType)
+	require.Equal(t, "abc", msg.MessageId)
+	require.Equal(t, "hook.TelegramWebhookPayload", msg.Type)
+	require.Equal(t, AppID, msg.AppId)
+	require.Equal(t, amqp.Persistent, msg.DeliveryMode)
+	require.False(t, msg.Timestamp.IsZero())
+	require.Equal(t, amqp.Table{"x-schema-version": "1"}, msg.Headers)
+	require.Equal(t, []byte("body"), msg.Body)
+}
+
+func TestFromDelivery(t *testing.T) {
+	d := fromDelivery(amqp.Delivery{
+		ContentType: "application/x-protobuf",
+		MessageId:   "abc",
+		Type:        "hook.RegisterWebhookRequest",
+		Headers:     amqp.Table{"x-schema-version": "1", "x-retries": int32(3)},
+		Body:        []byte("body"),
+	})
+
+	require.Equal(t, map[string]string{
+		HeaderContentType:  "application/x-protobuf",
+		HeaderMessageID:    "abc",
+		HeaderType:         "hook.RegisterWebhookRequest",
+		"x-schema-version": "1",
+	}, d.Headers)
+	require.Equal(t, []byte("body"), d.Body)
+}
+
+func TestAMQPPublisher_confirmLoop(t *testing.T) {
+	p := &AMQPPublisher{
+		inFlight: make(chan struct{}, maxInFlight),
+		pending:  map[uint64]chan amqp.Confirmation{},
+	}
+	waiters := map[uint64]chan amqp.Confirmation{}
+	for tag := uint64(1); tag <= 3; tag++ {
+		p.inFlight <- struct{}{}
+		waiters[tag] = make(chan amqp.Confirmation, 1)
+		p.pending[tag] = waiters[tag]
+	}
+
+	confirms := make(chan amqp.Confirmation, maxInFlight)
+	done := make(chan struct{})
+	go func() {
+		p.confirmLoop(confirms)
+		close(done)
+	}()
+
+	// Confirms for tags nobody waits on any more are dropped.
+	confirms <- amqp.Confirmation{DeliveryTag: 9, Ack: true}
+	confirms <- amqp.Confirmation{DeliveryTag: 2, Ack: false}
+	confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+	require.Equal(t, amqp.Confirmation{DeliveryTag: 1, Ack: true}, <-waiters[1])
+	require.Equal(t, amqp.Confirmation{DeliveryTag: 2, Ack: false}, <-waiters[2])
+
+	close(confirms)
+	<-done
+	_, ok := <-waiters[3]
+	require.False(t, ok, "a publish still waiting fails once the channel closes")
+	require.Empty(t, p.pending)
+	require.Empty(t, p.inFlight, "every slot is freed")
+	require.True(t, p.closed)
+}
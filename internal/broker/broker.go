@@ -0,0 +1,135 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Well-known header names. Backends map them onto native message
+// properties where the protocol has them (e.g. AMQP content_type, message_id, type).
+const (
+	HeaderContentType = "content-type"
+	HeaderMessageID   = "message-id"
+	HeaderType        = "type"
+)
+
+// AppID identifies this service as the producer of every message.
+const AppID = "murmapp.hook"
+
+// Publisher delivers messages to a topic on a message broker.
+// Publish returns only once the broker has acknowledged the message,
+// so a nil error means the message is durably accepted.
+type Publisher interface {
+	Publish(ctx context.Context, topic string, headers map[string]string, body []byte) error
+	Close() error
+}
+
+// ExchangePublisher is implemented by backends that can publish to an
+// exchange other than the one they were opened with.
+type ExchangePublisher interface {
+	PublishExchange(ctx context.Context, exchange, topic string, headers map[string]string, body []byte) error
+}
+
+// PublishTo publishes to exchange, or to the publisher's own exchange when
+// exchange is empty. Backends without exchanges (Kafka) refuse an override.
+func PublishTo(ctx context.Context, p Publisher, exchange, topic string, headers map[string]string, body []byte) error {
+	if exchange == "" {
+		return p.Publish(ctx, topic, headers, body)
+	}
+	ep, ok := p.(ExchangePublisher)
+	if !ok {
+		return fmt.Errorf("broker %T cannot publish to exchange %q", p, exchange)
+	}
+	return ep.PublishExchange(ctx, exchange, topic, headers, body)
+}
+
+// Delivery is a message received from a queue.
+type Delivery struct {
+	Headers map[string]string
+	Body    []byte
+}
+
+// Consumer receives messages from a queue. Consume blocks until ctx is done
+// or the subscription fails. A message whose handler returns an error is
+// rejected without requeueing, so it ends up in the dead-letter queue,
+// unless the error is a *RetryError.
+type Consumer interface {
+	Consume(ctx context.Context, queue string, handle func(context.Context, Delivery) error) error
+}
+
+// DefaultRetryDelay is how long a consumer waits before requeueing a message
+// whose RetryError names no delay.
+const DefaultRetryDelay = time.Second
+
+// RetryError asks the consumer to requeue the message after After instead
+// of dead-lettering it, for failures that may pass, such as a dependency
+// being down.
+type RetryError struct {
+	Err   error
+	After time.Duration
+}
+
+// Retry wraps err in a *RetryError.
+func Retry(err error, after time.Duration) error {
+	return &RetryError{Err: err, After: after}
+}
+
+func (e *RetryError) Error() string { return e.Err.Error() }
+
+func (e *RetryError) Unwrap() error { return e.Err }
+
+// retryDelay reports whether err asks for a retry and after how long.
+func retryDelay(err error) (time.Duration, bool) {
+	var retry *RetryError
+	if !errors.As(err, &retry) {
+		return 0, false
+	}
+	if retry.After <= 0 {
+		return DefaultRetryDelay, true
+	}
+	return retry.After, true
+}
+
+// DefaultExchange returns the exchange a publisher opened with Open(rawURL,
+// exchange) publishes to when none is given: exchange itself, or "" for
+// Kafka, which has no exchanges. Message signatures cover it.
+func DefaultExchange(rawURL, exchange string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Scheme == "kafka" {
+		return ""
+	}
+	return exchange
+}
+
+// Open selects a Publisher implementation by the URL scheme:
+//
+//	amqp://, amqps://  RabbitMQ (topic is the routing key on exchange)
+//	nats://            NATS JetStream (subject is "<exchange>.<topic>")
+//	kafka://           Kafka (topic is used as-is, hosts are comma-separated)
+//	memory://          in-process publisher for tests and local runs
+func Open(rawURL, exchange string) (Publisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker URL: %w", err)
+	}
+
+	var p Publisher
+	switch u.Scheme {
+	case "amqp", "amqps":
+		p, err = DialAMQP(rawURL, exchange)
+	case "nats":
+		p, err = DialNATS(rawURL, exchange)
+	case "kafka":
+		p, err = DialKafka(u)
+	case "memory":
+		p = NewMemoryPublisher()
+	default:
+		return nil, fmt.Errorf("unsupported broker URL scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,80 @@
+package broker_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"murmapp.hook/internal/broker"
+)
+
+func TestOpen_memory(t *testing.T) {
+	pub, err := broker.Open("memory://", "murmapp")
+	require.NoError(t, err)
+	require.IsType(t, &broker.MemoryPublisher{}, pub)
+}
+
+func TestDefaultExchange(t *testing.T) {
+	require.Equal(t, "murmapp", broker.DefaultExchange("amqp://localhost", "murmapp"))
+	require.Equal(t, "murmapp", broker.DefaultExchange("nats://localhost", "murmapp"))
+	require.Equal(t, "", broker.DefaultExchange("kafka://localhost:9092", "murmapp"), "Kafka has no exchanges")
+}
+
+func TestRetry(t *testing.T) {
+	cause := errors.New("bot api down")
+	err := fmt.Errorf("install: %w", broker.Retry(cause, time.Minute))
+
+	var retry *broker.RetryError
+	require.ErrorAs(t, err, &retry)
+	require.Equal(t, time.Minute, retry.After)
+	require.ErrorIs(t, err, cause)
+	require.EqualError(t, err, "install: bot api down")
+}
+
+func TestOpen_unsupportedScheme(t *testing.T) {
+	_, err := broker.Open("redis://localhost:6379", "murmapp")
+	require.ErrorContains(t, err, "unsupported broker URL scheme")
+}
+
+func TestOpen_kafkaWithoutHost(t *testing.T) {
+	_, err := broker.Open("kafka://", "murmapp")
+	require.Error(t, err)
+}
+
+func TestMemoryPublisher(t *testing.T) {
+	pub := broker.NewMemoryPublisher()
+	ctx := context.Background()
+
+	require.NoError(t, pub.Publish(ctx, "telegram.messages.in", map[string]string{"k": "v"}, []byte("body")))
+
+	msgs := pub.Messages()
+	require.Len(t, msgs, 1)
+	require.Equal(t, "telegram.messages.in", msgs[0].Topic)
+	require.Equal(t, "v", msgs[0].Headers["k"])
+	require.Equal(t, []byte("body"), msgs[0].Body)
+
+	pub.FailWith(errors.New("down"))
+	require.Error(t, pub.Publish(ctx, "telegram.messages.in", nil, nil))
+	require.Len(t, pub.Messages(), 1)
+}
+
+func TestPublishTo(t *testing.T) {
+	pub := broker.NewMemoryPublisher()
+	ctx := context.Background()
+
+	require.NoError(t, broker.PublishTo(ctx, pub, "", "a", nil, []byte("1")))
+	require.NoError(t, broker.PublishTo(ctx, pub, "tenant", "b", nil, []byte("2")))
+
+	msgs := pub.Messages()
+	require.Equal(t, "", msgs[0].Exchange)
+	require.Equal(t, "tenant", msgs[1].Exchange)
+	require.Equal(t, "b", msgs[1].Topic)
+
+	kafka, err := broker.Open("kafka://localhost:9092", "murmapp")
+	require.NoError(t, err)
+	defer kafka.Close()
+	require.ErrorContains(t, broker.PublishTo(ctx, kafka, "tenant", "b", nil, nil), "cannot publish to exchange")
+}
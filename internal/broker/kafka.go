@@ -0,0 +1,57 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaPublisher writes synchronously to Kafka and waits for all in-sync replicas.
+type KafkaPublisher struct {
+	w *kafka.Writer
+}
+
+// DialKafka builds a writer for kafka://host1:9092,host2:9092.
+func DialKafka(u *url.URL) (*KafkaPublisher, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("kafka URL must list at least one broker host")
+	}
+
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(u.Host, ",")...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		// Publish is synchronous and on the webhook path: with kafka-go's
+		// default 1s BatchTimeout every call would wait a second for the batch
+		// to fill before Telegram gets its answer. Each write is its own batch.
+		BatchSize:    1,
+		BatchTimeout: time.Millisecond,
+	}
+	return &KafkaPublisher{w: w}, nil
+}
+
+// Publish writes body to topic and returns once the write is acknowledged.
+func (p *KafkaPublisher) Publish(ctx context.Context, topic string, headers map[string]string, body []byte) error {
+	msg := kafka.Message{
+		Topic:   topic,
+		Value:   body,
+		Headers: []kafka.Header{{Key: "app-id", Value: []byte(AppID)}},
+	}
+	// Keying by message ID keeps redeliveries of the same update on one partition.
+	if id, ok := headers[HeaderMessageID]; ok {
+		msg.Key = []byte(id)
+	}
+	for k, v := range headers {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+	return p.w.WriteMessages(ctx, msg)
+}
+
+// Close flushes and closes the writer.
+func (p *KafkaPublisher) Close() error {
+	return p.w.Close()
+}
@@ -0,0 +1,20 @@
+package broker
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialKafka_writesWithoutBatchDelay(t *testing.T) {
+	u, err := url.Parse("kafka://localhost:9092")
+	require.NoError(t, err)
+	p, err := DialKafka(u)
+	require.NoError(t, err)
+	defer p.Close()
+
+	require.Equal(t, 1, p.w.BatchSize, "a synchronous publish must not wait for a batch to fill")
+	require.LessOrEqual(t, p.w.BatchTimeout, 10*time.Millisecond)
+}
@@ -0,0 +1,61 @@
+package broker
+
+import (
+	"context"
+	"sync"
+)
+
+// Message is a message captured by MemoryPublisher.
+type Message struct {
+	// Exchange is empty for messages sent with Publish.
+	Exchange string
+	Topic    string
+	Headers  map[string]string
+	Body     []byte
+}
+
+// MemoryPublisher keeps published messages in memory. It is safe for concurrent use.
+type MemoryPublisher struct {
+	mu       sync.Mutex
+	messages []Message
+	err      error
+}
+
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+// Publish records the message, or returns the error set with FailWith.
+func (p *MemoryPublisher) Publish(ctx context.Context, topic string, headers map[string]string, body []byte) error {
+	return p.PublishExchange(ctx, "", topic, headers, body)
+}
+
+// PublishExchange records the message with its exchange.
+func (p *MemoryPublisher) PublishExchange(ctx context.Context, exchange, topic string, headers map[string]string, body []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+	p.messages = append(p.messages, Message{Exchange: exchange, Topic: topic, Headers: headers, Body: body})
+	return nil
+}
+
+// FailWith makes every subsequent Publish return err; nil restores normal behavior.
+func (p *MemoryPublisher) FailWith(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+// Messages returns a copy of everything published so far.
+func (p *MemoryPublisher) Messages() []Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Message(nil), p.messages...)
+}
+
+func (p *MemoryPublisher) Close() error {
+	return nil
+}
@@ -0,0 +1,61 @@
+package broker
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// NATSPublisher publishes to NATS JetStream. The target stream must cover
+// the "<exchange>.>" subjects; JetStream acknowledges each stored message.
+type NATSPublisher struct {
+	nc     *nats.Conn
+	js     jetstream.JetStream
+	prefix string
+}
+
+// DialNATS connects to a NATS server and opens a JetStream context.
+func DialNATS(rawURL, exchange string) (*NATSPublisher, error) {
+	nc, err := nats.Connect(rawURL, nats.Name("murmapp.hook"))
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	return &NATSPublisher{nc: nc, js: js, prefix: exchange}, nil
+}
+
+// Publish sends body to the "<exchange>.<topic>" subject and waits for the JetStream ack.
+func (p *NATSPublisher) Publish(ctx context.Context, topic string, headers map[string]string, body []byte) error {
+	return p.PublishExchange(ctx, p.prefix, topic, headers, body)
+}
+
+// PublishExchange publishes under another subject prefix; a stream must
+// cover "<exchange>.>" as well.
+func (p *NATSPublisher) PublishExchange(ctx context.Context, exchange, topic string, headers map[string]string, body []byte) error {
+	msg := nats.NewMsg(exchange + "." + topic)
+	msg.Data = body
+	msg.Header.Set("app-id", AppID)
+	for k, v := range headers {
+		msg.Header.Set(k, v)
+	}
+	// JetStream drops messages whose Nats-Msg-Id it has already stored
+	// within the stream's duplicate window.
+	if id, ok := headers[HeaderMessageID]; ok {
+		msg.Header.Set(jetstream.MsgIDHeader, id)
+	}
+
+	_, err := p.js.PublishMsg(ctx, msg)
+	return err
+}
+
+// Close drains pending messages and closes the connection.
+func (p *NATSPublisher) Close() error {
+	return p.nc.Drain()
+}
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRU_evictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLRU[string, int](2, 0)
+
+	require.False(t, c.Add("a", 1))
+	require.False(t, c.Add("b", 2))
+	_, _ = c.Get("a")
+	require.False(t, c.Add("c", 3))
+
+	_, ok := c.Get("b")
+	require.False(t, ok, "b should have been evicted")
+	v, ok := c.Get("a")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+	require.Equal(t, 2, c.Len())
+}
+
+func TestLRU_addReportsExisting(t *testing.T) {
+	c := NewLRU[int64, struct{}](10, 0)
+
+	require.False(t, c.Add(1, struct{}{}))
+	require.True(t, c.Add(1, struct{}{}))
+
+	c.Remove(1)
+	require.False(t, c.Add(1, struct{}{}))
+}
+
+func TestLRU_ttl(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := NewLRU[string, int](10, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.Add("a", 1)
+	now = now.Add(30 * time.Second)
+	_, ok := c.Get("a")
+	require.True(t, ok)
+
+	now = now.Add(time.Minute)
+	_, ok = c.Get("a")
+	require.False(t, ok)
+	require.False(t, c.Add("a", 1), "expired entry must not count as present")
+}
+
+func TestLRU_purge(t *testing.T) {
+	c := NewLRU[string, int](10, 0)
+	c.Add("a", 1)
+	c.Purge()
+	require.Equal(t, 0, c.Len())
+}
+
+func TestLRU_range(t *testing.T) {
+	c := NewLRU[string, int](10, 0)
+	c.Add("a", 1)
+	c.Add("b", 2)
+
+	var keys []string
+	c.Range(func(k string, v int) bool {
+		keys = append(keys, k)
+		return true
+	})
+	require.Equal(t, []string{"b", "a"}, keys)
+}
+
+func TestLRU_getOrAdd(t *testing.T) {
+	c := NewLRU[string, int](10, 0)
+
+	v, existed := c.GetOrAdd("a", 1)
+	require.False(t, existed)
+	require.Equal(t, 1, v)
+
+	v, existed = c.GetOrAdd("a", 2)
+	require.True(t, existed)
+	require.Equal(t, 1, v)
+}
@@ -0,0 +1,137 @@
+// Package clientip derives the address of the client behind a request,
+// trusting forwarding headers only when they were set by a known proxy.
+package clientip
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type ctxKey struct{}
+
+// Resolver determines the client address of a request. X-Forwarded-For and
+// X-Real-IP are honoured only when the immediate peer is a trusted proxy.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+func NewResolver(trustedProxies []netip.Prefix) *Resolver {
+	return &Resolver{trusted: trustedProxies}
+}
+
+// Resolve returns the client address. X-Forwarded-For is walked from the
+// right, skipping trusted proxies, so a client cannot spoof its address by
+// prepending entries. It returns an invalid Addr when the peer address
+// cannot be parsed.
+func (res *Resolver) Resolve(r *http.Request) netip.Addr {
+	peer := peerAddr(r)
+	if !peer.IsValid() || !res.isTrusted(peer) {
+		return peer
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				// A malformed hop means everything left of it is unverifiable.
+				return peer
+			}
+			addr = addr.Unmap()
+			if !res.isTrusted(addr) {
+				return addr
+			}
+			peer = addr
+		}
+		return peer
+	}
+
+	if real, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return real.Unmap()
+	}
+	return peer
+}
+
+func (res *Resolver) isTrusted(addr netip.Addr) bool {
+	return Contains(res.trusted, addr)
+}
+
+// Middleware stores the resolved client address in the request context.
+func (res *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if addr := res.Resolve(r); addr.IsValid() {
+			r = r.WithContext(context.WithValue(r.Context(), ctxKey{}, addr))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// FromContext returns the address stored by Middleware.
+func FromContext(ctx context.Context) (netip.Addr, bool) {
+	addr, ok := ctx.Value(ctxKey{}).(netip.Addr)
+	return addr, ok
+}
+
+// String returns the client address for logging: the resolved address when
+// Middleware ran, otherwise the host part of r.RemoteAddr.
+func String(r *http.Request) string {
+	if addr, ok := FromContext(r.Context()); ok {
+		return addr.String()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Contains reports whether addr falls in any of prefixes.
+func Contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePrefixes parses a comma-separated list of CIDRs or bare addresses.
+func ParsePrefixes(list string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			p, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, err
+		}
+		addr = addr.Unmap()
+		out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return out, nil
+}
+
+func peerAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
@@ -0,0 +1,75 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolver_untrustedPeerIgnoresHeaders(t *testing.T) {
+	res := NewResolver(mustPrefixes(t, "10.0.0.0/8"))
+
+	r := httptest.NewRequest("POST", "/", nil)
+	r.RemoteAddr = "203.0.113.7:5555"
+	r.Header.Set("X-Forwarded-For", "149.154.167.1")
+	r.Header.Set("X-Real-IP", "149.154.167.1")
+
+	require.Equal(t, netip.MustParseAddr("203.0.113.7"), res.Resolve(r))
+}
+
+func TestResolver_forwardedFor(t *testing.T) {
+	res := NewResolver(mustPrefixes(t, "10.0.0.0/8"))
+
+	r := httptest.NewRequest("POST", "/", nil)
+	r.RemoteAddr = "10.0.0.2:5555"
+	// The leftmost entry is client-controlled and must be ignored.
+	r.Header.Set("X-Forwarded-For", "1.1.1.1, 149.154.167.1, 10.0.0.3")
+
+	require.Equal(t, netip.MustParseAddr("149.154.167.1"), res.Resolve(r))
+}
+
+func TestResolver_realIP(t *testing.T) {
+	res := NewResolver(mustPrefixes(t, "10.0.0.1"))
+
+	r := httptest.NewRequest("POST", "/", nil)
+	r.RemoteAddr = "10.0.0.1:5555"
+	r.Header.Set("X-Real-IP", "91.108.4.10")
+
+	require.Equal(t, netip.MustParseAddr("91.108.4.10"), res.Resolve(r))
+}
+
+func TestMiddleware_storesAddress(t *testing.T) {
+	res := NewResolver(nil)
+
+	var got string
+	h := res.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = String(r)
+	}))
+
+	r := httptest.NewRequest("POST", "/", nil)
+	r.RemoteAddr = "[::ffff:149.154.167.1]:443"
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	require.Equal(t, "149.154.167.1", got)
+}
+
+func TestParsePrefixes(t *testing.T) {
+	got, err := ParsePrefixes("149.154.160.0/20, 10.1.2.3 ,")
+	require.NoError(t, err)
+	require.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("149.154.160.0/20"),
+		netip.MustParsePrefix("10.1.2.3/32"),
+	}, got)
+
+	_, err = ParsePrefixes("not-a-cidr")
+	require.Error(t, err)
+}
+
+func mustPrefixes(t *testing.T, list string) []netip.Prefix {
+	t.Helper()
+	p, err := ParsePrefixes(list)
+	require.NoError(t, err)
+	return p
+}
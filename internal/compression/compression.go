@@ -0,0 +1,118 @@
+// Package compression shrinks redacted payloads before they are encrypted.
+// Ciphertext does not compress, so this is the only point where large
+// updates (media groups, long entity lists) can be made smaller on the wire.
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codecs recorded in TelegramWebhookPayload.compression.
+const (
+	None = ""
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// gzipWriters pools writers, which allocate large tables on creation.
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// Compressor compresses payloads of at least minSize bytes with one codec.
+// It is safe for concurrent use.
+type Compressor struct {
+	codec   string
+	minSize int
+	zstd    *zstd.Encoder
+}
+
+// New returns a Compressor for codec ("gzip" or "zstd"). Payloads shorter
+// than minSize are left as they are.
+func New(codec string, minSize int) (*Compressor, error) {
+	c := &Compressor{codec: codec, minSize: minSize}
+	switch codec {
+	case Gzip:
+	case Zstd:
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		c.zstd = enc
+	default:
+		return nil, fmt.Errorf("unsupported compression codec %q", codec)
+	}
+	return c, nil
+}
+
+// Compress returns data compressed and the codec used. Data below the
+// threshold, or that does not get smaller, is returned unchanged with None.
+func (c *Compressor) Compress(data []byte) ([]byte, string, error) {
+	if len(data) < c.minSize {
+		return data, None, nil
+	}
+
+	var out []byte
+	switch c.codec {
+	case Zstd:
+		out = c.zstd.EncodeAll(data, make([]byte, 0, len(data)/2))
+	case Gzip:
+		var buf bytes.Buffer
+		w := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(w)
+		w.Reset(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, None, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, None, err
+		}
+		out = buf.Bytes()
+	}
+
+	if len(out) >= len(data) {
+		return data, None, nil
+	}
+	return out, c.codec, nil
+}
+
+// Decompress reverses Compress. It refuses to produce more than maxSize
+// bytes, so a small message cannot expand without bound.
+func Decompress(data []byte, codec string, maxSize int64) ([]byte, error) {
+	var r io.Reader
+	switch codec {
+	case None:
+		return data, nil
+	case Gzip:
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	case Zstd:
+		dec, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(maxSize)))
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		r = dec
+	default:
+		return nil, fmt.Errorf("unsupported compression codec %q", codec)
+	}
+
+	out, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > maxSize {
+		return nil, fmt.Errorf("decompressed payload exceeds %d bytes", maxSize)
+	}
+	return out, nil
+}
@@ -0,0 +1,113 @@
+package compression
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// corpus returns the fixture updates compacted the way the hook publishes
+// redacted JSON.
+func corpus(tb testing.TB) map[string][]byte {
+	tb.Helper()
+	paths, err := filepath.Glob("testdata/*.json")
+	require.NoError(tb, err)
+	require.NotEmpty(tb, paths)
+
+	out := map[string][]byte{}
+	for _, p := range paths {
+		raw, err := os.ReadFile(p)
+		require.NoError(tb, err)
+		var buf bytes.Buffer
+		require.NoError(tb, json.Compact(&buf, raw))
+		out[filepath.Base(p)] = buf.Bytes()
+	}
+	return out
+}
+
+func TestCompressor_roundTrip(t *testing.T) {
+	for _, codec := range []string{Gzip, Zstd} {
+		t.Run(codec, func(t *testing.T) {
+			c, err := New(codec, 512)
+			require.NoError(t, err)
+
+			for name, data := range corpus(t) {
+				out, used, err := c.Compress(data)
+				require.NoError(t, err)
+				if len(data) < 512 {
+					require.Equal(t, None, used, name)
+					require.Equal(t, data, out, name)
+					continue
+				}
+				require.Equal(t, codec, used, name)
+				require.Less(t, len(out), len(data), name)
+
+				got, err := Decompress(out, used, 1<<20)
+				require.NoError(t, err)
+				require.Equal(t, data, got, name)
+			}
+		})
+	}
+}
+
+func TestCompressor_incompressible(t *testing.T) {
+	c, err := New(Zstd, 0)
+	require.NoError(t, err)
+
+	data := []byte("x")
+	out, used, err := c.Compress(data)
+	require.NoError(t, err)
+	require.Equal(t, None, used, "output that does not shrink is not used")
+	require.Equal(t, data, out)
+}
+
+func TestDecompress_limit(t *testing.T) {
+	for _, codec := range []string{Gzip, Zstd} {
+		c, err := New(codec, 0)
+		require.NoError(t, err)
+		out, _, err := c.Compress(bytes.Repeat([]byte("a"), 1<<16))
+		require.NoError(t, err)
+
+		_, err = Decompress(out, codec, 1024)
+		require.Error(t, err, codec)
+	}
+
+	_, err := New("lz4", 0)
+	require.ErrorContains(t, err, "unsupported")
+}
+
+// BenchmarkCompress reports, per codec, the share of broker bytes left after
+// compressing the fixture corpus (ratio) and the bytes saved per update.
+func BenchmarkCompress(b *testing.B) {
+	docs := corpus(b)
+	for _, codec := range []string{Gzip, Zstd} {
+		b.Run(codec, func(b *testing.B) {
+			c, err := New(codec, 1024)
+			require.NoError(b, err)
+
+			var raw, compressed int
+			for _, data := range docs {
+				out, _, err := c.Compress(data)
+				require.NoError(b, err)
+				raw += len(data)
+				compressed += len(out)
+			}
+
+			b.SetBytes(int64(raw))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, data := range docs {
+					if _, _, err := c.Compress(data); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.ReportMetric(float64(compressed)/float64(raw), "ratio")
+			b.ReportMetric(float64(raw-compressed)/float64(len(docs)), "saved-B/update")
+		})
+	}
+}
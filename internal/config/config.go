@@ -1,136 +1,945 @@
 package config
 
 import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ed25519"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 
 	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"time"
 
 	"github.com/eugene-ruby/xencryptor/xsecrets"
+	"murmapp.hook/internal/compression"
+	"murmapp.hook/internal/envelope"
+	"murmapp.hook/internal/hybrid"
+	"murmapp.hook/internal/masterkey"
+	"murmapp.hook/internal/secret"
 )
 
 // MasterEncryptionKey is the master secret key injected at build time via -ldflags.
-// It is used for decrypting sensitive data like private RSA keys.
+// It is used for decrypting sensitive data like private RSA keys. It is the
+// legacy fallback when no runtime master key source is configured.
 var MasterEncryptionKey string
 
+// masterKey is the key LoadConfig obtained from the configured source.
+var masterKey *secret.Bytes
+
+// MasterKeyBytes returns the master key loaded by LoadConfig, or the -ldflags
+// value before LoadConfig has run.
 func MasterKeyBytes() []byte {
+	if masterKey != nil {
+		return masterKey.Bytes()
+	}
 	return []byte(MasterEncryptionKey)
 }
 
 // Config holds all configuration for the application.
 type Config struct {
 	AppPort     string
+	AdminPort   string
 	WebhookPath string
-	MasterKey   string
-	RabbitMQ    RabbitMQConfig
-	Encryption  EncryptionConfig
+	// MaxBodyBytes caps the webhook request body; Telegram updates are a few KiB.
+	MaxBodyBytes int64
+	Broker       BrokerConfig
+	RabbitMQ     RabbitMQConfig
+	Encryption   EncryptionConfig
+	Dedupe       DedupeConfig
+	XIDCache     XIDCacheConfig
+	Ingest       IngestConfig
+	AuthGuard    AuthGuardConfig
+	ClientIP     ClientIPConfig
+	RateLimit    RateLimitConfig
+	Registry     RegistryConfig
+	Telegram     TelegramConfig
+	// BotOverridesFile is a JSON file of per-webhook overrides; optional.
+	BotOverridesFile string
+	AdminToken       string
+	// PrivacyRules replace the embedded privacy_keys.conf rules when set.
+	PrivacyRules []string
 }
 
-type RabbitMQConfig struct {
+// BrokerConfig selects the message broker; the URL scheme picks the backend
+// (amqp, amqps, nats, kafka or memory).
+type BrokerConfig struct {
 	URL string
 }
 
+type RabbitMQConfig struct {
+	URL      string
+	Topology TopologyConfig
+}
+
+// TopologyConfig describes the exchanges, queues and bindings the hook
+// declares at startup so that messages are routable before any consumer connects.
+type TopologyConfig struct {
+	Exchange           string
+	DeadLetterExchange string
+	QueueType          string // "quorum" or "classic"
+	MessageTTL         time.Duration
+	MaxLength          int
+	Overflow           string
+	Queues             []QueueConfig
+}
+
+// QueueConfig binds a durable queue to the main exchange by routing key.
+type QueueConfig struct {
+	Name       string
+	RoutingKey string
+}
+
+// DedupeConfig controls suppression of Telegram redeliveries by update_id.
+// A zero WindowSize disables deduplication.
+type DedupeConfig struct {
+	WindowSize int
+	TTL        time.Duration
+	RedisURL   string
+	// RedisTimeout bounds each command sent to RedisURL.
+	RedisTimeout time.Duration
+}
+
+// XIDCacheConfig bounds the cache of XIDs whose encrypted mapping was
+// published recently. A zero Size disables the cache.
+type XIDCacheConfig struct {
+	Size int
+	TTL  time.Duration
+}
+
+// IngestConfig selects between processing updates inline in the HTTP handler
+// ("sync") and acknowledging them once queued for a worker pool ("async").
+type IngestConfig struct {
+	Mode         string
+	QueueSize    int
+	Workers      int
+	DrainTimeout time.Duration
+}
+
+// Async reports whether updates are processed by the worker pool.
+func (c IngestConfig) Async() bool {
+	return c.Mode == "async"
+}
+
+// AuthGuardConfig controls the per-IP lockout after repeated invalid
+// secret tokens. It is off unless MaxFailures is set: behind a proxy that is
+// not listed in TRUSTED_PROXIES every client shares one address.
+type AuthGuardConfig struct {
+	MaxFailures int
+	Window      time.Duration
+	Lockout     time.Duration
+}
+
+// ClientIPConfig controls how the client address is derived and which
+// addresses may call the webhook endpoint.
+type ClientIPConfig struct {
+	// AllowlistEnabled rejects webhook calls from outside Allowlist with 403.
+	AllowlistEnabled bool
+	Allowlist        []netip.Prefix
+	// TrustedProxies are peers whose X-Forwarded-For / X-Real-IP are believed.
+	TrustedProxies []netip.Prefix
+}
+
+// RateLimitConfig holds token-bucket limits in requests per second. A zero
+// rate disables that limit. Update types in ShedTypes are dropped first when
+// a bucket runs low.
+type RateLimitConfig struct {
+	GlobalRate   float64
+	GlobalBurst  int
+	WebhookRate  float64
+	WebhookBurst int
+	ShedTypes    []string
+}
+
+// RegistryConfig enables the bot registry. When enabled, registrations are
+// consumed from Queue, answered on ReplyTopic, and webhooks for unregistered
+// IDs are refused.
+type RegistryConfig struct {
+	Enabled    bool
+	Queue      string
+	ReplyTopic string
+	// Path of the bbolt file holding registrations; empty keeps them in memory.
+	Path      string
+	CacheSize int
+	CacheTTL  time.Duration
+	// RotationGrace is how long a webhook ID replaced by a token rotation
+	// is still served.
+	RotationGrace time.Duration
+}
+
+// TelegramConfig is used to call setWebhook for registered bots. The hook
+// only manages webhooks when PublicURL is set.
+type TelegramConfig struct {
+	APIURL string
+	// PublicURL is how Telegram reaches the hook, e.g. https://hook.example.com.
+	PublicURL      string
+	AllowedUpdates []string
+	MaxConnections int
+	Timeout        time.Duration
+}
+
 type EncryptionConfig struct {
-	SecretSaltStr           string
-	SecretSalt              []byte
-	MasterKeyBytes          []byte
+	SecretSaltStr string
+	// SecretSalt, MasterKey and PayloadEncryptionKey are decrypted key
+	// material: shared by pointer when the config is copied, redacted when
+	// printed and zeroed by Destroy.
+	SecretSalt *secret.Bytes
+	MasterKey  *secret.Bytes
+	// MasterKeySource describes where the master key was read from, for logs.
+	MasterKeySource         string
 	PayloadEncryptionKeyStr string
 	CasterPublicRSAKeyStr   string
-	PayloadEncryptionKey    []byte
+	PayloadEncryptionKey    *secret.Bytes
 	CasterPublicRSAKey      *rsa.PublicKey
+	PayloadKeyID            string
+	CasterKeyID             string
+	// PayloadAlgorithm is envelope.AlgorithmDirect unless
+	// PAYLOAD_ENCRYPTION=envelope.
+	PayloadAlgorithm string
+	// DataKeyTTL is how long an envelope data key is reused; zero draws a
+	// new one per payload.
+	DataKeyTTL time.Duration
+	// PayloadCompression is the codec applied before encryption ("gzip" or
+	// "zstd"); empty disables compression.
+	PayloadCompression string
+	// CompressionMinBytes is the smallest payload that gets compressed.
+	CompressionMinBytes int
+	// PayloadAAD binds payload metadata to encrypted_payload as associated
+	// data (aad_version 1); off until every consumer passes it to Open.
+	PayloadAAD bool
+	// TelegramIDScheme is how EncryptedTelegramID is encrypted: "rsa" with
+	// CasterPublicRSAKey or "x25519" with CasterX25519Key.
+	TelegramIDScheme   string
+	CasterX25519KeyStr string
+	CasterX25519Key    *ecdh.PublicKey
+	CasterX25519KeyID  string
+	// EphemeralKeyTTL is how long an X25519 ephemeral key is reused.
+	EphemeralKeyTTL time.Duration
+	// CasterKeysFile is CASTER_KEYS_FILE; empty means the only recipient is
+	// the caster key selected by TelegramIDScheme.
+	CasterKeysFile string
+	// CasterRecipients are the keys every Telegram ID is encrypted for.
+	CasterRecipients *hybrid.Recipients
+	// PayloadKeyringFile is PAYLOAD_KEYRING_FILE; empty means the keyring
+	// holds only PayloadEncryptionKey.
+	PayloadKeyringFile string
+	// PayloadKeyring seals payloads with its active key. PayloadEncryptionKey
+	// still decrypts api_key_bot.
+	PayloadKeyring *envelope.Keyring
+	// SigningKeyStr is SIGNING_KEY, an Ed25519 seed encrypted like
+	// PAYLOAD_ENCRYPTION_KEY; empty leaves published messages unsigned.
+	SigningKeyStr string
+	// SigningKey is the ed25519.PrivateKey expanded from the seed.
+	SigningKey *secret.Bytes
+	// LockSecrets is SECRETS_MLOCK: key material is locked into memory so
+	// it is never swapped to disk.
+	LockSecrets bool
+}
+
+// Destroy zeroes the decrypted key material held by the config and every
+// copy of it, including the payload keyring and the caster recipients.
+// Nothing that uses the keys may run afterwards.
+func (e *EncryptionConfig) Destroy() {
+	e.MasterKey.Destroy()
+	e.SecretSalt.Destroy()
+	e.PayloadEncryptionKey.Destroy()
+	e.SigningKey.Destroy()
+	if e.PayloadKeyring != nil {
+		e.PayloadKeyring.Destroy()
+	}
+	if e.CasterRecipients != nil {
+		e.CasterRecipients.Destroy()
+	}
+}
+
+// lockSecrets locks the decrypted key material into memory. The payload
+// keyring and the caster recipients keep locking what a reload brings in.
+func lockSecrets(enc *EncryptionConfig) error {
+	lockers := map[string]interface{ Lock() error }{
+		"master key":             enc.MasterKey,
+		"SECRET_SALT":            enc.SecretSalt,
+		"PAYLOAD_ENCRYPTION_KEY": enc.PayloadEncryptionKey,
+		"payload keyring":        enc.PayloadKeyring,
+		"caster recipients":      enc.CasterRecipients,
+	}
+	if enc.SigningKey != nil {
+		lockers["SIGNING_KEY"] = enc.SigningKey
+	}
+	for name, l := range lockers {
+		if err := l.Lock(); err != nil {
+			return fmt.Errorf("SECRETS_MLOCK: %s: %w (is RLIMIT_MEMLOCK high enough?)", name, err)
+		}
+	}
+	return nil
 }
 
 type defaultENV struct {
-	appPort string
+	appPort            string
+	adminPort          string
+	dedupeWindowSize   int
+	dedupeTTL          time.Duration
+	dedupeRedisTimeout time.Duration
+	xidCacheSize       int
+	xidCacheTTL        time.Duration
+	ingestMode         string
+	ingestQueueSize    int
+	ingestWorkers      int
+	ingestDrainTimeout time.Duration
+	maxBodyBytes       int
+	authFailureWindow  time.Duration
+	authLockout        time.Duration
+	ipAllowlist        string
+	globalBurst        int
+	webhookBurst       int
+	registryQueue      string
+	registryReplyTopic string
+	registryCacheSize  int
+	registryCacheTTL   time.Duration
+	rotationGrace      time.Duration
+	telegramAPIURL     string
+	maxConnections     int
+	telegramTimeout    time.Duration
+	exchange           string
+	deadLetterExchange string
+	queueType          string
+	overflow           string
+}
+
+// defaultSettings are the values of settings that are not set.
+var defaultSettings = defaultENV{
+	appPort:            "8080",
+	adminPort:          "9090",
+	dedupeWindowSize:   10000,
+	dedupeTTL:          time.Hour,
+	dedupeRedisTimeout: 200 * time.Millisecond,
+	xidCacheSize:       100000,
+	xidCacheTTL:        time.Hour,
+	ingestMode:         "sync",
+	ingestQueueSize:    1000,
+	ingestWorkers:      4,
+	ingestDrainTimeout: 10 * time.Second,
+	maxBodyBytes:       1 << 20,
+	authFailureWindow:  time.Minute,
+	authLockout:        15 * time.Minute,
+	// Telegram's published webhook source ranges.
+	ipAllowlist:        "149.154.160.0/20,91.108.4.0/22",
+	globalBurst:        200,
+	webhookBurst:       50,
+	registryQueue:      "telegram.webhook.register",
+	registryReplyTopic: "telegram.webhook.registered",
+	registryCacheSize:  10000,
+	registryCacheTTL:   time.Minute,
+	rotationGrace:      time.Hour,
+	telegramAPIURL:     "https://api.telegram.org",
+	maxConnections:     40,
+	telegramTimeout:    10 * time.Second,
+	exchange:           "murmapp",
+	deadLetterExchange: "murmapp.dlx",
+	queueType:          "quorum",
+	overflow:           "reject-publish",
 }
 
-// LoadConfig reads environment variables and returns a Config instance.
+// LoadConfig reads the config file, if any, and environment variables and
+// returns a Config instance. Settings are checked before any key is loaded
+// or decrypted; either step reports all of its problems in one
+// *ValidationError.
 func LoadConfig() (*Config, error) {
-	defaultValues := &defaultENV{
-		appPort: "8080",
+	file, problems, err := loadFile(configFilePath())
+	if err != nil {
+		return nil, err
 	}
+	l := &loader{file: file, problems: problems}
 
+	cfg := l.load(&defaultSettings)
+	if len(l.problems) > 0 {
+		return nil, &ValidationError{Problems: l.problems}
+	}
+
+	l.loadKeys(&cfg.Encryption)
+	if len(l.problems) > 0 {
+		cfg.Encryption.Destroy()
+		return nil, &ValidationError{Problems: l.problems}
+	}
+	return cfg, nil
+}
+
+// AdminConfig is what the admin CLI needs to reach a running hook.
+type AdminConfig struct {
+	Port  string
+	Token string
+}
+
+// LoadAdminConfig reads ADMIN_PORT and ADMIN_TOKEN from the config file and
+// the environment the way LoadConfig does, without loading any key.
+func LoadAdminConfig() (AdminConfig, error) {
+	file, problems, err := loadFile(configFilePath())
+	if err != nil {
+		return AdminConfig{}, err
+	}
+	l := &loader{file: file, problems: problems}
+	cfg := AdminConfig{
+		Port:  l.str("ADMIN_PORT", defaultSettings.adminPort),
+		Token: l.get("ADMIN_TOKEN"),
+	}
+	if len(l.problems) > 0 {
+		return AdminConfig{}, &ValidationError{Problems: l.problems}
+	}
+	return cfg, nil
+}
+
+// load reads every setting that needs no key material.
+func (l *loader) load(defaults *defaultENV) *Config {
 	cfg := &Config{
-		AppPort:     os.Getenv("APP_PORT"),
-		WebhookPath: os.Getenv("WEB_HOOK_PATH"),
+		AppPort:          l.str("APP_PORT", defaults.appPort),
+		AdminPort:        l.str("ADMIN_PORT", defaults.adminPort),
+		WebhookPath:      l.required("WEB_HOOK_PATH"),
+		AdminToken:       l.get("ADMIN_TOKEN"),
+		BotOverridesFile: l.get("BOT_OVERRIDES_FILE"),
+		PrivacyRules:     l.list("PRIVACY_RULES"),
+		Broker: BrokerConfig{
+			URL: l.get("BROKER_URL"),
+		},
 		RabbitMQ: RabbitMQConfig{
-			URL: os.Getenv("RABBITMQ_URL"),
+			URL: l.get("RABBITMQ_URL"),
 		},
-		Encryption: EncryptionConfig{
-			SecretSaltStr:           os.Getenv("SECRET_SALT"),
-			PayloadEncryptionKeyStr: os.Getenv("PAYLOAD_ENCRYPTION_KEY"),
-			CasterPublicRSAKeyStr:   os.Getenv("CASTER_PUBLIC_KEY_RAW_BASE64"),
+	}
+	if cfg.Broker.URL == "" {
+		cfg.Broker.URL = cfg.RabbitMQ.URL
+	}
+	l.check(cfg.Broker.URL != "", "BROKER_URL", "BROKER_URL or RABBITMQ_URL must be set")
+
+	maxBody := l.int("MAX_BODY_BYTES", defaults.maxBodyBytes)
+	l.check(maxBody > 0, "MAX_BODY_BYTES", "MAX_BODY_BYTES must be greater than zero")
+	cfg.MaxBodyBytes = int64(maxBody)
+
+	cfg.RabbitMQ.Topology = l.loadTopology(defaults)
+	cfg.Dedupe = l.loadDedupe(defaults)
+	cfg.XIDCache = l.loadXIDCache(defaults)
+	cfg.Ingest = l.loadIngest(defaults)
+	cfg.AuthGuard = l.loadAuthGuard(defaults)
+	cfg.ClientIP = l.loadClientIP(defaults)
+	cfg.RateLimit = l.loadRateLimit(defaults)
+	cfg.Registry = l.loadRegistry(defaults)
+	cfg.Telegram = l.loadTelegram(defaults)
+	// The token issued to a bot reaches Telegram only through setWebhook.
+	l.check(!cfg.Registry.Enabled || cfg.Telegram.PublicURL != "", "PUBLIC_URL", "PUBLIC_URL must be set when REGISTRY_ENABLED is set")
+	if cfg.Registry.Enabled {
+		cfg.RabbitMQ.Topology.Queues = append(cfg.RabbitMQ.Topology.Queues,
+			QueueConfig{Name: cfg.Registry.Queue, RoutingKey: cfg.Registry.Queue},
+			QueueConfig{Name: cfg.Registry.ReplyTopic, RoutingKey: cfg.Registry.ReplyTopic},
+		)
+	}
+	cfg.Encryption = l.loadEncryption()
+	return cfg
+}
+
+// loadKeys loads the master key and decrypts and parses the keys. A key
+// that fails is reported and the others are still tried.
+func (l *loader) loadKeys(enc *EncryptionConfig) {
+	if !l.loadMasterKey(enc) {
+		return
+	}
+	l.decryptKeys(enc)
+	l.loadPublicKeys(enc)
+	l.loadSigningKey(enc)
+	if len(l.problems) > 0 {
+		return
+	}
+	l.loadPayloadKeyring(enc)
+	l.loadCasterRecipients(enc)
+	if enc.LockSecrets && len(l.problems) == 0 {
+		if err := lockSecrets(enc); err != nil {
+			l.fail("SECRETS_MLOCK", "%v", err)
+		}
+	}
+}
+
+func (l *loader) loadTopology(defaults *defaultENV) TopologyConfig {
+	return TopologyConfig{
+		Exchange:           l.str("RABBITMQ_EXCHANGE", defaults.exchange),
+		DeadLetterExchange: l.str("RABBITMQ_DLX", defaults.deadLetterExchange),
+		QueueType:          l.oneOf("RABBITMQ_QUEUE_TYPE", defaults.queueType, "quorum", "classic"),
+		Overflow:           l.str("RABBITMQ_OVERFLOW", defaults.overflow),
+		MessageTTL:         l.duration("RABBITMQ_MESSAGE_TTL", 0),
+		MaxLength:          l.int("RABBITMQ_MAX_LENGTH", 0),
+		Queues: []QueueConfig{
+			{Name: "telegram.messages.in", RoutingKey: "telegram.messages.in"},
+			{Name: "telegram.encrypted.id", RoutingKey: "telegram.encrypted.id"},
 		},
 	}
+}
 
-	if cfg.WebhookPath == "" {
-		return nil, fmt.Errorf("WEB_HOOK_PATH environment variable must be set")
+func (l *loader) loadDedupe(defaults *defaultENV) DedupeConfig {
+	d := DedupeConfig{
+		WindowSize:   l.int("DEDUPE_WINDOW_SIZE", defaults.dedupeWindowSize),
+		TTL:          l.duration("DEDUPE_TTL", defaults.dedupeTTL),
+		RedisURL:     l.get("DEDUPE_REDIS_URL"),
+		RedisTimeout: l.duration("DEDUPE_REDIS_TIMEOUT", defaults.dedupeRedisTimeout),
 	}
-	if cfg.RabbitMQ.URL == "" {
-		return nil, fmt.Errorf("RABBITMQ_URL environment variable must be set")
+	l.check(d.TTL > 0, "DEDUPE_TTL", "DEDUPE_TTL must be greater than zero")
+	l.check(d.RedisTimeout > 0, "DEDUPE_REDIS_TIMEOUT", "DEDUPE_REDIS_TIMEOUT must be greater than zero")
+	return d
+}
+
+func (l *loader) loadXIDCache(defaults *defaultENV) XIDCacheConfig {
+	return XIDCacheConfig{
+		Size: l.int("XID_CACHE_SIZE", defaults.xidCacheSize),
+		TTL:  l.duration("XID_CACHE_TTL", defaults.xidCacheTTL),
 	}
-	if cfg.Encryption.SecretSaltStr == "" {
-		return nil, fmt.Errorf("SECRET_SALT environment variable must be set")
+}
+
+func (l *loader) loadIngest(defaults *defaultENV) IngestConfig {
+	c := IngestConfig{
+		Mode:         l.oneOf("INGEST_MODE", defaults.ingestMode, "sync", "async"),
+		QueueSize:    l.int("INGEST_QUEUE_SIZE", defaults.ingestQueueSize),
+		Workers:      l.int("INGEST_WORKERS", defaults.ingestWorkers),
+		DrainTimeout: l.duration("INGEST_DRAIN_TIMEOUT", defaults.ingestDrainTimeout),
 	}
-	if cfg.Encryption.PayloadEncryptionKeyStr == "" {
-		return nil, fmt.Errorf("PAYLOAD_ENCRYPTION_KEY environment variable must be set")
+	l.check(c.Workers > 0, "INGEST_WORKERS", "INGEST_WORKERS must be at least 1")
+	l.check(c.QueueSize > 0 || !c.Async(), "INGEST_QUEUE_SIZE", "INGEST_QUEUE_SIZE must be at least 1 when INGEST_MODE=async")
+	return c
+}
+
+func (l *loader) loadAuthGuard(defaults *defaultENV) AuthGuardConfig {
+	c := AuthGuardConfig{
+		MaxFailures: l.int("AUTH_MAX_FAILURES", 0),
+		Window:      l.duration("AUTH_FAILURE_WINDOW", defaults.authFailureWindow),
+		Lockout:     l.duration("AUTH_LOCKOUT", defaults.authLockout),
 	}
-	if cfg.Encryption.CasterPublicRSAKeyStr == "" {
-		return nil, fmt.Errorf("CASTER_PUBLIC_KEY_RAW_BASE64 environment variable must be set")
+	if c.MaxFailures > 0 {
+		l.check(c.Window > 0, "AUTH_FAILURE_WINDOW", "AUTH_FAILURE_WINDOW must be greater than zero")
+		l.check(c.Lockout > 0, "AUTH_LOCKOUT", "AUTH_LOCKOUT must be greater than zero")
 	}
-	if cfg.AppPort == "" {
-		cfg.AppPort = defaultValues.appPort
+	return c
+}
+
+func (l *loader) loadClientIP(defaults *defaultENV) ClientIPConfig {
+	c := ClientIPConfig{AllowlistEnabled: l.bool("IP_ALLOWLIST_ENABLED", false)}
+	allowlist, ok := l.prefixes("IP_ALLOWLIST", defaults.ipAllowlist)
+	if ok && c.AllowlistEnabled && len(allowlist) == 0 {
+		l.fail("IP_ALLOWLIST", "IP_ALLOWLIST must not be empty when IP_ALLOWLIST_ENABLED is set")
 	}
-	if MasterEncryptionKey == "" {
-		return nil, fmt.Errorf("MasterEncryptionKey must be injected at build time with -ldflags")
+	c.Allowlist = allowlist
+	c.TrustedProxies, _ = l.prefixes("TRUSTED_PROXIES", "")
+	return c
+}
+
+func (l *loader) loadRateLimit(defaults *defaultENV) RateLimitConfig {
+	return RateLimitConfig{
+		GlobalRate:   l.float("RATE_LIMIT_GLOBAL_RPS", 0),
+		GlobalBurst:  l.int("RATE_LIMIT_GLOBAL_BURST", defaults.globalBurst),
+		WebhookRate:  l.float("RATE_LIMIT_WEBHOOK_RPS", 0),
+		WebhookBurst: l.int("RATE_LIMIT_WEBHOOK_BURST", defaults.webhookBurst),
+		ShedTypes:    l.list("RATE_LIMIT_SHED_TYPES"),
 	}
-	cfg.Encryption.MasterKeyBytes = MasterKeyBytes()
+}
 
-	if err := decryptKeys(&cfg.Encryption); err != nil {
-		return nil, err
+func (l *loader) loadRegistry(defaults *defaultENV) RegistryConfig {
+	c := RegistryConfig{
+		Enabled:       l.bool("REGISTRY_ENABLED", false),
+		Queue:         l.str("REGISTRY_QUEUE", defaults.registryQueue),
+		ReplyTopic:    l.str("REGISTRY_REPLY_TOPIC", defaults.registryReplyTopic),
+		Path:          l.get("REGISTRY_PATH"),
+		CacheSize:     l.int("REGISTRY_CACHE_SIZE", defaults.registryCacheSize),
+		CacheTTL:      l.duration("REGISTRY_CACHE_TTL", defaults.registryCacheTTL),
+		RotationGrace: l.duration("REGISTRY_ROTATION_GRACE", defaults.rotationGrace),
 	}
-	if err := loadPublicKey(&cfg.Encryption); err != nil {
-		return nil, err
+	l.check(c.RotationGrace > 0, "REGISTRY_ROTATION_GRACE", "REGISTRY_ROTATION_GRACE must be positive, got %s", c.RotationGrace)
+	return c
+}
+
+func (l *loader) loadTelegram(defaults *defaultENV) TelegramConfig {
+	c := TelegramConfig{
+		APIURL:         l.str("TELEGRAM_API_URL", defaults.telegramAPIURL),
+		PublicURL:      l.get("PUBLIC_URL"),
+		AllowedUpdates: l.list("TELEGRAM_ALLOWED_UPDATES"),
+		MaxConnections: l.int("TELEGRAM_MAX_CONNECTIONS", defaults.maxConnections),
+		Timeout:        l.duration("TELEGRAM_TIMEOUT", defaults.telegramTimeout),
 	}
+	if c.PublicURL != "" {
+		u, err := url.Parse(c.PublicURL)
+		l.check(err == nil && u.Scheme == "https" && u.Host != "", "PUBLIC_URL", "PUBLIC_URL must be an https:// URL, got %q", c.PublicURL)
+	}
+	if u, err := url.Parse(c.APIURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		l.fail("TELEGRAM_API_URL", "TELEGRAM_API_URL must be an http(s):// URL, got %q", c.APIURL)
+	}
+	l.check(c.MaxConnections >= 1 && c.MaxConnections <= 100, "TELEGRAM_MAX_CONNECTIONS",
+		"TELEGRAM_MAX_CONNECTIONS must be between 1 and 100, got %d", c.MaxConnections)
+	l.check(c.Timeout > 0, "TELEGRAM_TIMEOUT", "TELEGRAM_TIMEOUT must be greater than zero")
+	return c
+}
 
-	return cfg, nil
+// loadEncryption reads the encryption settings; the keys themselves are
+// loaded by loadKeys.
+func (l *loader) loadEncryption() EncryptionConfig {
+	enc := EncryptionConfig{
+		SecretSaltStr:           l.required("SECRET_SALT"),
+		PayloadEncryptionKeyStr: l.required("PAYLOAD_ENCRYPTION_KEY"),
+		CasterPublicRSAKeyStr:   l.get("CASTER_PUBLIC_KEY_RAW_BASE64"),
+		CasterX25519KeyStr:      l.get("CASTER_X25519_PUBLIC_KEY_RAW_BASE64"),
+		TelegramIDScheme:        l.oneOf("TELEGRAM_ID_ENCRYPTION", "rsa", "rsa", "x25519"),
+		EphemeralKeyTTL:         l.duration("TELEGRAM_ID_EPHEMERAL_TTL", time.Minute),
+		CasterKeysFile:          l.get("CASTER_KEYS_FILE"),
+		PayloadKeyringFile:      l.get("PAYLOAD_KEYRING_FILE"),
+		SigningKeyStr:           l.get("SIGNING_KEY"),
+		DataKeyTTL:              l.duration("PAYLOAD_DATA_KEY_TTL", 0),
+		CompressionMinBytes:     l.int("PAYLOAD_COMPRESSION_MIN_BYTES", 1024),
+		PayloadAAD:              l.bool("PAYLOAD_AAD", false),
+		LockSecrets:             l.bool("SECRETS_MLOCK", false),
+	}
+
+	if enc.CasterKeysFile == "" {
+		switch enc.TelegramIDScheme {
+		case "rsa":
+			l.check(enc.CasterPublicRSAKeyStr != "", "CASTER_PUBLIC_KEY_RAW_BASE64",
+				"CASTER_PUBLIC_KEY_RAW_BASE64 must be set when TELEGRAM_ID_ENCRYPTION=rsa")
+		case "x25519":
+			l.check(enc.CasterX25519KeyStr != "", "CASTER_X25519_PUBLIC_KEY_RAW_BASE64",
+				"CASTER_X25519_PUBLIC_KEY_RAW_BASE64 must be set when TELEGRAM_ID_ENCRYPTION=x25519")
+		}
+	}
+
+	switch l.oneOf("PAYLOAD_ENCRYPTION", "direct", "direct", "envelope") {
+	case "envelope":
+		enc.PayloadAlgorithm = envelope.AlgorithmEnvelope
+	case "direct":
+		enc.PayloadAlgorithm = envelope.AlgorithmDirect
+	}
+	if codec := l.oneOf("PAYLOAD_COMPRESSION", "none", "none", compression.Gzip, compression.Zstd); codec != "none" {
+		enc.PayloadCompression = codec
+	}
+
+	// The key is only fetched by loadKeys, once every setting is valid.
+	l.masterKey = l.masterKeyProvider()
+	return enc
+}
+
+// masterKeyProvider selects the master key source from MASTER_KEY_SOURCE, or
+// when that is unset from whichever of MASTER_KEY_FILE, MASTER_KEY_URL and
+// MASTER_ENCRYPTION_KEY is set, falling back to the -ldflags value. It
+// returns nil when the settings are reported as invalid.
+func (l *loader) masterKeyProvider() masterkey.Provider {
+	source := l.get("MASTER_KEY_SOURCE")
+	if source == "" {
+		switch {
+		case l.get("MASTER_KEY_FILE") != "":
+			source = "file"
+		case l.get("MASTER_KEY_URL") != "":
+			source = "http"
+		case os.Getenv("MASTER_ENCRYPTION_KEY") != "":
+			source = "env"
+		default:
+			source = "ldflags"
+		}
+	}
+
+	switch source {
+	case "file":
+		path := l.get("MASTER_KEY_FILE")
+		if path == "" {
+			l.fail("MASTER_KEY_FILE", "MASTER_KEY_FILE must be set when MASTER_KEY_SOURCE=file")
+			return nil
+		}
+		return masterkey.File{Path: path}
+	case "env":
+		return masterkey.Env{Var: "MASTER_ENCRYPTION_KEY"}
+	case "stdin":
+		return masterkey.Stdin{Reader: os.Stdin}
+	case "http":
+		url := l.get("MASTER_KEY_URL")
+		timeout := l.duration("MASTER_KEY_TIMEOUT", 10*time.Second)
+		if url == "" {
+			l.fail("MASTER_KEY_URL", "MASTER_KEY_URL must be set when MASTER_KEY_SOURCE=http")
+			return nil
+		}
+		return masterkey.HTTP{
+			URL:    url,
+			Token:  l.get("MASTER_KEY_TOKEN"),
+			Client: &http.Client{Timeout: timeout},
+		}
+	case "ldflags":
+		if MasterEncryptionKey == "" {
+			l.fail("MASTER_KEY_SOURCE", "no master key: set MASTER_KEY_FILE, MASTER_KEY_URL, MASTER_ENCRYPTION_KEY or MASTER_KEY_SOURCE=stdin, or inject MasterEncryptionKey with -ldflags")
+			return nil
+		}
+		return masterkey.Static{Source: "-ldflags", Key: MasterEncryptionKey}
+	default:
+		l.fail("MASTER_KEY_SOURCE", "MASTER_KEY_SOURCE must be file, env, stdin, http or ldflags, got %q", source)
+		return nil
+	}
+}
+
+// loadMasterKey fetches the master key and reports whether it succeeded.
+func (l *loader) loadMasterKey(enc *EncryptionConfig) bool {
+	key, err := l.masterKey.MasterKey(context.Background())
+	if err != nil {
+		l.fail("MASTER_KEY_SOURCE", "master key from %s: %v", l.masterKey.Name(), err)
+		return false
+	}
+	masterKey = secret.New(key)
+	enc.MasterKey = masterKey
+	enc.MasterKeySource = l.masterKey.Name()
+	return true
+}
+
+func (l *loader) loadPayloadKeyring(enc *EncryptionConfig) {
+	if enc.PayloadKeyringFile == "" {
+		ring, err := envelope.NewKeyring(enc.PayloadKeyID, []envelope.Key{
+			{ID: enc.PayloadKeyID, Material: enc.PayloadEncryptionKey.Bytes()},
+		})
+		if err != nil {
+			l.fail("PAYLOAD_ENCRYPTION_KEY", "PAYLOAD_ENCRYPTION_KEY: %v", err)
+			return
+		}
+		enc.PayloadKeyring = ring
+		return
+	}
+
+	active, keys, err := ReadPayloadKeyring(enc.PayloadKeyringFile)
+	if err != nil {
+		l.fail("PAYLOAD_KEYRING_FILE", "%v", err)
+		return
+	}
+	ring, err := envelope.NewKeyring(active, keys)
+	ClearKeys(keys)
+	if err != nil {
+		l.fail("PAYLOAD_KEYRING_FILE", "PAYLOAD_KEYRING_FILE %s: %v", enc.PayloadKeyringFile, err)
+		return
+	}
+	enc.PayloadKeyring = ring
+}
+
+// keyringFile is the layout of PAYLOAD_KEYRING_FILE. Keys are encrypted like
+// PAYLOAD_ENCRYPTION_KEY.
+type keyringFile struct {
+	Active string `json:"active"`
+	Keys   []struct {
+		ID        string    `json:"id"`
+		Key       string    `json:"key"`
+		NotBefore time.Time `json:"not_before"`
+		NotAfter  time.Time `json:"not_after"`
+	} `json:"keys"`
+}
+
+// ReadPayloadKeyring reads and decrypts a keyring file, returning the ID of
+// the active key and all keys. A key without an id is named by its KeyID.
+// The keyring copies the keys, so pass them to ClearKeys once it has them.
+func ReadPayloadKeyring(path string) (string, []envelope.Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("read PAYLOAD_KEYRING_FILE: %w", err)
+	}
+	var f keyringFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return "", nil, fmt.Errorf("parse PAYLOAD_KEYRING_FILE %s: %w", path, err)
+	}
+
+	wrapKey := xsecrets.DeriveKey(MasterKeyBytes(), "payload")
+	keys := make([]envelope.Key, 0, len(f.Keys))
+	for i, k := range f.Keys {
+		material, err := xsecrets.DecryptBase64WithKey(k.Key, wrapKey)
+		if err != nil {
+			ClearKeys(keys)
+			return "", nil, fmt.Errorf("PAYLOAD_KEYRING_FILE %s: failed to decrypt key %d: %w", path, i, err)
+		}
+		id := k.ID
+		if id == "" {
+			id = KeyID(material)
+		}
+		keys = append(keys, envelope.Key{ID: id, Material: material, NotBefore: k.NotBefore, NotAfter: k.NotAfter})
+	}
+	return f.Active, keys, nil
+}
+
+// ClearKeys zeroes the material of keys read by ReadPayloadKeyring.
+func ClearKeys(keys []envelope.Key) {
+	for _, k := range keys {
+		clear(k.Material)
+	}
 }
 
-func decryptKeys(enc *EncryptionConfig) error {
+func (l *loader) decryptKeys(enc *EncryptionConfig) {
 	keyPayload := xsecrets.DeriveKey(MasterKeyBytes(), "payload")
 	decryptedPayloadKey, err := xsecrets.DecryptBase64WithKey(enc.PayloadEncryptionKeyStr, keyPayload)
-
 	if err != nil {
-		return fmt.Errorf("failed to decrypt PAYLOAD_ENCRYPTION_KEY: %w", err)
+		l.fail("PAYLOAD_ENCRYPTION_KEY", "failed to decrypt PAYLOAD_ENCRYPTION_KEY: %v", err)
+	} else {
+		enc.PayloadEncryptionKey = secret.New(decryptedPayloadKey)
+		enc.PayloadKeyID = KeyID(decryptedPayloadKey)
 	}
-	enc.PayloadEncryptionKey = decryptedPayloadKey
 
 	keySalt := xsecrets.DeriveKey(MasterKeyBytes(), "salt")
 	decryptedSecretSaltKey, err := xsecrets.DecryptBase64WithKey(enc.SecretSaltStr, keySalt)
 	if err != nil {
-		return fmt.Errorf("failed to decrypt SECRET_SALT: %w", err)
+		l.fail("SECRET_SALT", "failed to decrypt SECRET_SALT: %v", err)
+	} else {
+		enc.SecretSalt = secret.New(decryptedSecretSaltKey)
 	}
-	enc.SecretSalt = decryptedSecretSaltKey
-
-	return nil
 }
 
-func loadPublicKey(enc *EncryptionConfig) error {
-	encRSABase64 := enc.CasterPublicRSAKeyStr
-	derBytes, err := base64.RawStdEncoding.DecodeString(encRSABase64)
+func (l *loader) loadPublicKeys(enc *EncryptionConfig) {
+	if enc.CasterX25519KeyStr != "" {
+		l.loadX25519Key(enc)
+	}
+	if enc.CasterPublicRSAKeyStr == "" {
+		return
+	}
+
+	const name = "CASTER_PUBLIC_KEY_RAW_BASE64"
+	derBytes, err := base64.RawStdEncoding.DecodeString(enc.CasterPublicRSAKeyStr)
 	if err != nil {
-		return fmt.Errorf("failed to decode CASTER_PUBLIC_KEY_RAW_BASE64: %w", err)
+		l.fail(name, "failed to decode %s: %v", name, err)
+		return
 	}
 	pubKey, err := x509.ParsePKIXPublicKey(derBytes)
 	if err != nil {
-		return fmt.Errorf("failed to parse PublicKey: %w", err)
+		l.fail(name, "failed to parse %s: %v", name, err)
+		return
 	}
 	publicKey, ok := pubKey.(*rsa.PublicKey)
 	if !ok {
-		return fmt.Errorf("failed to decrypt PublicKey")
+		l.fail(name, "%s must be an RSA public key", name)
+		return
 	}
 	enc.CasterPublicRSAKey = publicKey
-	return nil
+	enc.CasterKeyID = KeyID(derBytes)
+}
+
+// loadCasterRecipients builds the recipient set from CASTER_KEYS_FILE, or
+// from the single caster key selected by TELEGRAM_ID_ENCRYPTION.
+func (l *loader) loadCasterRecipients(enc *EncryptionConfig) {
+	var recipients []hybrid.Recipient
+	switch {
+	case enc.CasterKeysFile != "":
+		var err error
+		if recipients, err = ReadCasterKeys(enc.CasterKeysFile, enc.EphemeralKeyTTL); err != nil {
+			l.fail("CASTER_KEYS_FILE", "%v", err)
+			return
+		}
+	case enc.TelegramIDScheme == "x25519":
+		recipients = []hybrid.Recipient{{
+			KeyID:     enc.CasterX25519KeyID,
+			Encrypter: hybrid.NewX25519Encrypter(enc.CasterX25519Key, enc.CasterX25519KeyID, enc.EphemeralKeyTTL),
+		}}
+	default:
+		recipients = []hybrid.Recipient{{
+			KeyID:     enc.CasterKeyID,
+			Encrypter: &hybrid.RSAEncrypter{Key: enc.CasterPublicRSAKey, KeyID: enc.CasterKeyID},
+		}}
+	}
+
+	set, err := hybrid.NewRecipients(recipients...)
+	if err != nil {
+		l.fail("CASTER_KEYS_FILE", "CASTER_KEYS_FILE %s: %v", enc.CasterKeysFile, err)
+		return
+	}
+	enc.CasterRecipients = set
+}
+
+// casterKeysFile is the layout of CASTER_KEYS_FILE. Keys are public, so they
+// are stored as raw base64 X.509 DER like CASTER_PUBLIC_KEY_RAW_BASE64.
+type casterKeysFile struct {
+	Recipients []struct {
+		ID  string `json:"id"`
+		Key string `json:"key"`
+	} `json:"recipients"`
+}
+
+// ReadCasterKeys reads the recipient keys from a CASTER_KEYS_FILE. Each key
+// is encrypted with RSA or X25519 depending on its type; a key without an
+// id is named by its KeyID.
+func ReadCasterKeys(path string, ephemeralTTL time.Duration) ([]hybrid.Recipient, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read CASTER_KEYS_FILE: %w", err)
+	}
+	var f casterKeysFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parse CASTER_KEYS_FILE %s: %w", path, err)
+	}
+
+	recipients := make([]hybrid.Recipient, 0, len(f.Recipients))
+	for i, r := range f.Recipients {
+		derBytes, err := base64.RawStdEncoding.DecodeString(r.Key)
+		if err != nil {
+			return nil, fmt.Errorf("CASTER_KEYS_FILE %s: failed to decode key %d: %w", path, i, err)
+		}
+		pubKey, err := x509.ParsePKIXPublicKey(derBytes)
+		if err != nil {
+			return nil, fmt.Errorf("CASTER_KEYS_FILE %s: failed to parse key %d: %w", path, i, err)
+		}
+		id := r.ID
+		if id == "" {
+			id = KeyID(derBytes)
+		}
+
+		var e hybrid.Encrypter
+		switch key := pubKey.(type) {
+		case *rsa.PublicKey:
+			e = &hybrid.RSAEncrypter{Key: key, KeyID: id}
+		case *ecdh.PublicKey:
+			if key.Curve() != ecdh.X25519() {
+				return nil, fmt.Errorf("CASTER_KEYS_FILE %s: key %d must be RSA or X25519", path, i)
+			}
+			e = hybrid.NewX25519Encrypter(key, id, ephemeralTTL)
+		default:
+			return nil, fmt.Errorf("CASTER_KEYS_FILE %s: key %d must be RSA or X25519, got %T", path, i, pubKey)
+		}
+		recipients = append(recipients, hybrid.Recipient{KeyID: id, Encrypter: e})
+	}
+	return recipients, nil
+}
+
+func (l *loader) loadX25519Key(enc *EncryptionConfig) {
+	const name = "CASTER_X25519_PUBLIC_KEY_RAW_BASE64"
+	derBytes, err := base64.RawStdEncoding.DecodeString(enc.CasterX25519KeyStr)
+	if err != nil {
+		l.fail(name, "failed to decode %s: %v", name, err)
+		return
+	}
+	pubKey, err := x509.ParsePKIXPublicKey(derBytes)
+	if err != nil {
+		l.fail(name, "failed to parse %s: %v", name, err)
+		return
+	}
+	publicKey, ok := pubKey.(*ecdh.PublicKey)
+	if !ok || publicKey.Curve() != ecdh.X25519() {
+		l.fail(name, "%s must be an X25519 public key", name)
+		return
+	}
+	enc.CasterX25519Key = publicKey
+	enc.CasterX25519KeyID = KeyID(derBytes)
+}
+
+// loadSigningKey decrypts SIGNING_KEY with a key derived for "signing", so
+// the seed is never stored in the clear.
+func (l *loader) loadSigningKey(enc *EncryptionConfig) {
+	if enc.SigningKeyStr == "" {
+		return
+	}
+	seed, err := xsecrets.DecryptBase64WithKey(enc.SigningKeyStr, xsecrets.DeriveKey(MasterKeyBytes(), "signing"))
+	if err != nil {
+		l.fail("SIGNING_KEY", "failed to decrypt SIGNING_KEY: %v", err)
+		return
+	}
+	defer clear(seed)
+	if len(seed) != ed25519.SeedSize {
+		l.fail("SIGNING_KEY", "SIGNING_KEY must be a %d-byte Ed25519 seed, got %d bytes", ed25519.SeedSize, len(seed))
+		return
+	}
+	enc.SigningKey = secret.New(ed25519.NewKeyFromSeed(seed))
+}
+
+// KeyID returns a short stable fingerprint of key material that can be
+// published alongside ciphertexts without revealing the key itself.
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
 }
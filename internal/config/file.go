@@ -0,0 +1,262 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFile is the path of the YAML config file, set from the -config flag.
+// CONFIG_FILE is used when it is empty; without either, configuration comes
+// from the environment only.
+var ConfigFile string
+
+// fileSchema is the layout of the config file. Every setting maps to the
+// environment variable in its env tag, which overrides it when set, even to
+// an empty value. Lists are YAML
+// sequences in the file and comma-separated in the environment.
+type fileSchema struct {
+	Server struct {
+		Port         string `yaml:"port" env:"APP_PORT"`
+		AdminPort    string `yaml:"admin_port" env:"ADMIN_PORT"`
+		AdminToken   string `yaml:"admin_token" env:"ADMIN_TOKEN"`
+		WebhookPath  string `yaml:"webhook_path" env:"WEB_HOOK_PATH"`
+		MaxBodyBytes int    `yaml:"max_body_bytes" env:"MAX_BODY_BYTES"`
+		ClientIP     struct {
+			AllowlistEnabled bool     `yaml:"allowlist_enabled" env:"IP_ALLOWLIST_ENABLED"`
+			Allowlist        []string `yaml:"allowlist" env:"IP_ALLOWLIST"`
+			TrustedProxies   []string `yaml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+		} `yaml:"client_ip"`
+		Ingest struct {
+			Mode         string        `yaml:"mode" env:"INGEST_MODE"`
+			QueueSize    int           `yaml:"queue_size" env:"INGEST_QUEUE_SIZE"`
+			Workers      int           `yaml:"workers" env:"INGEST_WORKERS"`
+			DrainTimeout time.Duration `yaml:"drain_timeout" env:"INGEST_DRAIN_TIMEOUT"`
+		} `yaml:"ingest"`
+	} `yaml:"server"`
+
+	Broker struct {
+		URL         string `yaml:"url" env:"BROKER_URL"`
+		RabbitMQURL string `yaml:"rabbitmq_url" env:"RABBITMQ_URL"`
+		Topology    struct {
+			Exchange           string        `yaml:"exchange" env:"RABBITMQ_EXCHANGE"`
+			DeadLetterExchange string        `yaml:"dead_letter_exchange" env:"RABBITMQ_DLX"`
+			QueueType          string        `yaml:"queue_type" env:"RABBITMQ_QUEUE_TYPE"`
+			Overflow           string        `yaml:"overflow" env:"RABBITMQ_OVERFLOW"`
+			MessageTTL         time.Duration `yaml:"message_ttl" env:"RABBITMQ_MESSAGE_TTL"`
+			MaxLength          int           `yaml:"max_length" env:"RABBITMQ_MAX_LENGTH"`
+		} `yaml:"topology"`
+	} `yaml:"broker"`
+
+	Filter struct {
+		Rules            []string `yaml:"rules" env:"PRIVACY_RULES"`
+		BotOverridesFile string   `yaml:"bot_overrides_file" env:"BOT_OVERRIDES_FILE"`
+		Dedupe           struct {
+			WindowSize   int           `yaml:"window_size" env:"DEDUPE_WINDOW_SIZE"`
+			TTL          time.Duration `yaml:"ttl" env:"DEDUPE_TTL"`
+			RedisURL     string        `yaml:"redis_url" env:"DEDUPE_REDIS_URL"`
+			RedisTimeout time.Duration `yaml:"redis_timeout" env:"DEDUPE_REDIS_TIMEOUT"`
+		} `yaml:"dedupe"`
+		XIDCache struct {
+			Size int           `yaml:"size" env:"XID_CACHE_SIZE"`
+			TTL  time.Duration `yaml:"ttl" env:"XID_CACHE_TTL"`
+		} `yaml:"xid_cache"`
+	} `yaml:"filter"`
+
+	Crypto struct {
+		MasterKey struct {
+			Source  string        `yaml:"source" env:"MASTER_KEY_SOURCE"`
+			File    string        `yaml:"file" env:"MASTER_KEY_FILE"`
+			URL     string        `yaml:"url" env:"MASTER_KEY_URL"`
+			Token   string        `yaml:"token" env:"MASTER_KEY_TOKEN"`
+			Timeout time.Duration `yaml:"timeout" env:"MASTER_KEY_TIMEOUT"`
+		} `yaml:"master_key"`
+		SecretSalt           string `yaml:"secret_salt" env:"SECRET_SALT"`
+		PayloadEncryptionKey string `yaml:"payload_encryption_key" env:"PAYLOAD_ENCRYPTION_KEY"`
+		Payload              struct {
+			Encryption          string        `yaml:"encryption" env:"PAYLOAD_ENCRYPTION"`
+			DataKeyTTL          time.Duration `yaml:"data_key_ttl" env:"PAYLOAD_DATA_KEY_TTL"`
+			Compression         string        `yaml:"compression" env:"PAYLOAD_COMPRESSION"`
+			CompressionMinBytes int           `yaml:"compression_min_bytes" env:"PAYLOAD_COMPRESSION_MIN_BYTES"`
+			KeyringFile         string        `yaml:"keyring_file" env:"PAYLOAD_KEYRING_FILE"`
+			AAD                 bool          `yaml:"aad" env:"PAYLOAD_AAD"`
+		} `yaml:"payload"`
+		TelegramID struct {
+			Encryption     string        `yaml:"encryption" env:"TELEGRAM_ID_ENCRYPTION"`
+			CasterRSAKey   string        `yaml:"caster_rsa_public_key" env:"CASTER_PUBLIC_KEY_RAW_BASE64"`
+			CasterX25519   string        `yaml:"caster_x25519_public_key" env:"CASTER_X25519_PUBLIC_KEY_RAW_BASE64"`
+			CasterKeysFile string        `yaml:"caster_keys_file" env:"CASTER_KEYS_FILE"`
+			EphemeralTTL   time.Duration `yaml:"ephemeral_ttl" env:"TELEGRAM_ID_EPHEMERAL_TTL"`
+		} `yaml:"telegram_id"`
+		SigningKey   string `yaml:"signing_key" env:"SIGNING_KEY"`
+		SecretsMlock bool   `yaml:"secrets_mlock" env:"SECRETS_MLOCK"`
+	} `yaml:"crypto"`
+
+	Limits struct {
+		RateLimit struct {
+			GlobalRPS    float64  `yaml:"global_rps" env:"RATE_LIMIT_GLOBAL_RPS"`
+			GlobalBurst  int      `yaml:"global_burst" env:"RATE_LIMIT_GLOBAL_BURST"`
+			WebhookRPS   float64  `yaml:"webhook_rps" env:"RATE_LIMIT_WEBHOOK_RPS"`
+			WebhookBurst int      `yaml:"webhook_burst" env:"RATE_LIMIT_WEBHOOK_BURST"`
+			ShedTypes    []string `yaml:"shed_types" env:"RATE_LIMIT_SHED_TYPES"`
+		} `yaml:"rate_limit"`
+		Auth struct {
+			MaxFailures   int           `yaml:"max_failures" env:"AUTH_MAX_FAILURES"`
+			FailureWindow time.Duration `yaml:"failure_window" env:"AUTH_FAILURE_WINDOW"`
+			Lockout       time.Duration `yaml:"lockout" env:"AUTH_LOCKOUT"`
+		} `yaml:"auth"`
+	} `yaml:"limits"`
+
+	Registry struct {
+		Enabled       bool          `yaml:"enabled" env:"REGISTRY_ENABLED"`
+		Queue         string        `yaml:"queue" env:"REGISTRY_QUEUE"`
+		ReplyTopic    string        `yaml:"reply_topic" env:"REGISTRY_REPLY_TOPIC"`
+		Path          string        `yaml:"path" env:"REGISTRY_PATH"`
+		CacheSize     int           `yaml:"cache_size" env:"REGISTRY_CACHE_SIZE"`
+		CacheTTL      time.Duration `yaml:"cache_ttl" env:"REGISTRY_CACHE_TTL"`
+		RotationGrace time.Duration `yaml:"rotation_grace" env:"REGISTRY_ROTATION_GRACE"`
+	} `yaml:"registry"`
+
+	Telegram struct {
+		APIURL         string        `yaml:"api_url" env:"TELEGRAM_API_URL"`
+		PublicURL      string        `yaml:"public_url" env:"PUBLIC_URL"`
+		AllowedUpdates []string      `yaml:"allowed_updates" env:"TELEGRAM_ALLOWED_UPDATES"`
+		MaxConnections int           `yaml:"max_connections" env:"TELEGRAM_MAX_CONNECTIONS"`
+		Timeout        time.Duration `yaml:"timeout" env:"TELEGRAM_TIMEOUT"`
+	} `yaml:"telegram"`
+}
+
+// FieldError is one problem found in the configuration. Path is the setting
+// in the config file; the message names its environment variable.
+type FieldError struct {
+	Path    string
+	Message string
+}
+
+func (e FieldError) String() string {
+	return e.Path + ": " + e.Message
+}
+
+// ValidationError lists every problem found in the configuration, so they
+// can be fixed in one go.
+type ValidationError struct {
+	Problems []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid configuration (%d problem(s)):", len(e.Problems))
+	for _, p := range e.Problems {
+		b.WriteString("\n  " + p.String())
+	}
+	return b.String()
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// settingPaths maps every environment variable in fileSchema to the path of
+// its setting in the config file.
+var settingPaths = sync.OnceValue(func() map[string]string {
+	paths := map[string]string{}
+	var walk func(t reflect.Type, prefix string)
+	walk = func(t reflect.Type, prefix string) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			path := prefix + f.Tag.Get("yaml")
+			if f.Type.Kind() == reflect.Struct && f.Type != durationType {
+				walk(f.Type, path+".")
+				continue
+			}
+			paths[f.Tag.Get("env")] = path
+		}
+	}
+	walk(reflect.TypeOf(fileSchema{}), "")
+	return paths
+})
+
+// settingPath is where the environment variable name is set in the config
+// file; settings that only come from the environment report their name.
+func settingPath(name string) string {
+	if path, ok := settingPaths()[name]; ok {
+		return path
+	}
+	return name
+}
+
+// loadFile reads the config file and returns its settings by environment
+// variable name. Unknown keys and values of the wrong shape are returned as
+// problems rather than stopping the read.
+func loadFile(path string) (map[string]string, []FieldError, error) {
+	values := map[string]string{}
+	if path == "" {
+		return values, nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read config file: %w", err)
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, nil, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	if len(root.Content) == 0 {
+		return values, nil, nil
+	}
+
+	var problems []FieldError
+	flattenNode(root.Content[0], reflect.TypeOf(fileSchema{}), "", values, &problems)
+	return values, problems, nil
+}
+
+// flattenNode walks a YAML mapping alongside the schema type t and records
+// its scalar and list values in values.
+func flattenNode(node *yaml.Node, t reflect.Type, prefix string, values map[string]string, problems *[]FieldError) {
+	section := strings.TrimSuffix(prefix, ".")
+	if node.Kind != yaml.MappingNode {
+		*problems = append(*problems, FieldError{Path: section, Message: fmt.Sprintf("must be a mapping (line %d)", node.Line)})
+		return
+	}
+
+	fields := make(map[string]reflect.StructField, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		fields[t.Field(i).Tag.Get("yaml")] = t.Field(i)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		path := prefix + key.Value
+		f, ok := fields[key.Value]
+		if !ok {
+			*problems = append(*problems, FieldError{Path: path, Message: fmt.Sprintf("unknown setting (line %d)", key.Line)})
+			continue
+		}
+
+		switch {
+		case f.Type.Kind() == reflect.Struct && f.Type != durationType:
+			flattenNode(value, f.Type, path+".", values, problems)
+		case f.Type.Kind() == reflect.Slice:
+			var list []string
+			if err := value.Decode(&list); err != nil {
+				*problems = append(*problems, FieldError{Path: path, Message: fmt.Sprintf("must be a list of strings (line %d)", value.Line)})
+				continue
+			}
+			values[f.Tag.Get("env")] = strings.Join(list, ",")
+		case value.Kind != yaml.ScalarNode:
+			*problems = append(*problems, FieldError{Path: path, Message: fmt.Sprintf("must be a single value (line %d)", value.Line)})
+		default:
+			values[f.Tag.Get("env")] = value.Value
+		}
+	}
+}
+
+// configFilePath is the -config flag, or CONFIG_FILE.
+func configFilePath() string {
+	if ConfigFile != "" {
+		return ConfigFile
+	}
+	return os.Getenv("CONFIG_FILE")
+}
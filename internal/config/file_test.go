@@ -0,0 +1,174 @@
+package config_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"murmapp.hook/internal/config"
+)
+
+// moveEnvToFile writes the required settings, taken from the test
+// environment, into a config file and clears them from the environment.
+func moveEnvToFile(t *testing.T, extra string) string {
+	t.Helper()
+	yaml := `
+server:
+  webhook_path: ` + os.Getenv("WEB_HOOK_PATH") + `
+broker:
+  rabbitmq_url: ` + os.Getenv("RABBITMQ_URL") + `
+crypto:
+  secret_salt: "` + os.Getenv("SECRET_SALT") + `"
+  payload_encryption_key: "` + os.Getenv("PAYLOAD_ENCRYPTION_KEY") + `"
+  telegram_id:
+    caster_rsa_public_key: "` + os.Getenv("CASTER_PUBLIC_KEY_RAW_BASE64") + `"
+` + extra
+	for _, name := range []string{"WEB_HOOK_PATH", "RABBITMQ_URL", "SECRET_SALT", "PAYLOAD_ENCRYPTION_KEY", "CASTER_PUBLIC_KEY_RAW_BASE64"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+
+	path := filepath.Join(t.TempDir(), "hook.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(yaml), 0o600))
+	t.Setenv("CONFIG_FILE", path)
+	return path
+}
+
+func TestLoadConfig_File(t *testing.T) {
+	webhookPath := os.Getenv("WEB_HOOK_PATH")
+	path := moveEnvToFile(t, `
+limits:
+  rate_limit:
+    global_rps: 12.5
+    shed_types: [message_reaction, poll_answer]
+filter:
+  rules:
+    - message.from.id
+    - message.chat.id
+registry:
+  rotation_grace: 30m
+`)
+	cfg, err := config.LoadConfig()
+	require.NoError(t, err)
+	require.Equal(t, webhookPath, cfg.WebhookPath)
+	require.NotNil(t, cfg.Encryption.CasterPublicRSAKey)
+	require.Equal(t, 12.5, cfg.RateLimit.GlobalRate)
+	require.Equal(t, []string{"message_reaction", "poll_answer"}, cfg.RateLimit.ShedTypes)
+	require.Equal(t, []string{"message.from.id", "message.chat.id"}, cfg.PrivacyRules)
+	require.Equal(t, 30*time.Minute, cfg.Registry.RotationGrace)
+
+	t.Setenv("REGISTRY_ROTATION_GRACE", "2h")
+	t.Setenv("RATE_LIMIT_SHED_TYPES", "chat_member")
+	cfg, err = config.LoadConfig()
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Hour, cfg.Registry.RotationGrace, "the environment overrides the file")
+	require.Equal(t, []string{"chat_member"}, cfg.RateLimit.ShedTypes)
+
+	t.Setenv("CONFIG_FILE", "")
+	config.ConfigFile = path
+	defer func() { config.ConfigFile = "" }()
+	_, err = config.LoadConfig()
+	require.NoError(t, err, "the -config flag works like CONFIG_FILE")
+}
+
+func TestLoadConfig_FileValidationReport(t *testing.T) {
+	moveEnvToFile(t, `
+filter:
+  dedupe:
+    ttl: forever
+  xid_cache: 100
+  rulez: [message.from.id]
+limits:
+  rate_limit:
+    global_burst: many
+    shed_types: {message_reaction: true}
+`)
+	t.Setenv("INGEST_MODE", "eventually")
+
+	_, err := config.LoadConfig()
+	var verr *config.ValidationError
+	require.True(t, errors.As(err, &verr), err)
+
+	var paths []string
+	for _, p := range verr.Problems {
+		paths = append(paths, p.Path)
+	}
+	require.ElementsMatch(t, []string{
+		"filter.dedupe.ttl",              // not a duration
+		"filter.xid_cache",               // not a mapping
+		"filter.rulez",                   // unknown
+		"limits.rate_limit.global_burst", // not an integer
+		"limits.rate_limit.shed_types",   // not a list
+		"server.ingest.mode",             // from the environment
+	}, paths, err.Error())
+	require.ErrorContains(t, err, `server.ingest.mode: INGEST_MODE must be one of sync, async, got "eventually"`)
+	require.ErrorContains(t, err, "filter.rulez: unknown setting (line 16)", "problems carry their line in the file")
+}
+
+func TestLoadConfig_EnvValidationReport(t *testing.T) {
+	t.Setenv("WEB_HOOK_PATH", "")
+	t.Setenv("DEDUPE_TTL", "soon")
+	t.Setenv("TELEGRAM_ID_ENCRYPTION", "x25519")
+
+	_, err := config.LoadConfig()
+	var verr *config.ValidationError
+	require.True(t, errors.As(err, &verr), err)
+	require.Len(t, verr.Problems, 3, "all problems are reported, not only the first: %v", err)
+	require.ErrorContains(t, err, "server.webhook_path: WEB_HOOK_PATH must be set")
+	require.ErrorContains(t, err, "filter.dedupe.ttl: DEDUPE_TTL")
+	require.ErrorContains(t, err, "crypto.telegram_id.caster_x25519_public_key: CASTER_X25519_PUBLIC_KEY_RAW_BASE64 must be set")
+}
+
+func TestLoadConfig_SemanticValidationReport(t *testing.T) {
+	for name, value := range map[string]string{
+		"MAX_BODY_BYTES":           "0",
+		"INGEST_WORKERS":           "0",
+		"DEDUPE_TTL":               "0s",
+		"TELEGRAM_MAX_CONNECTIONS": "101",
+		"PUBLIC_URL":               "http://hook.example.com",
+		"TRUSTED_PROXIES":          "10.0.0.0/33",
+		"REGISTRY_ROTATION_GRACE":  "0s",
+		"AUTH_MAX_FAILURES":        "5",
+		"AUTH_FAILURE_WINDOW":      "0s",
+		"AUTH_LOCKOUT":             "0s",
+	} {
+		t.Setenv(name, value)
+	}
+
+	_, err := config.LoadConfig()
+	var verr *config.ValidationError
+	require.True(t, errors.As(err, &verr), err)
+
+	var paths []string
+	for _, p := range verr.Problems {
+		paths = append(paths, p.Path)
+	}
+	require.ElementsMatch(t, []string{
+		"server.max_body_bytes",
+		"server.ingest.workers",
+		"filter.dedupe.ttl",
+		"telegram.max_connections",
+		"telegram.public_url",
+		"server.client_ip.trusted_proxies",
+		"registry.rotation_grace",
+		"limits.auth.failure_window",
+		"limits.auth.lockout",
+	}, paths, err.Error())
+}
+
+func TestLoadConfig_EmptyEnvOverridesFile(t *testing.T) {
+	moveEnvToFile(t, `
+telegram:
+  public_url: http://hook.example.com
+`)
+	_, err := config.LoadConfig()
+	require.ErrorContains(t, err, "PUBLIC_URL must be an https:// URL")
+
+	t.Setenv("PUBLIC_URL", "")
+	cfg, err := config.LoadConfig()
+	require.NoError(t, err, "an empty environment variable overrides the file")
+	require.Empty(t, cfg.Telegram.PublicURL)
+}
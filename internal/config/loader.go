@@ -0,0 +1,148 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"murmapp.hook/internal/clientip"
+	"murmapp.hook/internal/masterkey"
+)
+
+// loader reads settings from the environment and the config file. Instead of
+// stopping at the first bad setting it records a problem and carries on with
+// the default, so LoadConfig can report every problem at once.
+type loader struct {
+	// file holds the config file settings by environment variable name.
+	file     map[string]string
+	problems []FieldError
+	// masterKey is the master key source selected by the settings.
+	masterKey masterkey.Provider
+}
+
+// get returns the environment variable name when it is set, even to an
+// empty value, and the config file setting for it otherwise.
+func (l *loader) get(name string) string {
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return l.file[name]
+}
+
+// fail records a problem with the setting read from name.
+func (l *loader) fail(name, format string, args ...any) {
+	l.problems = append(l.problems, FieldError{Path: settingPath(name), Message: fmt.Sprintf(format, args...)})
+}
+
+// check records a problem with name unless ok.
+func (l *loader) check(ok bool, name, format string, args ...any) {
+	if !ok {
+		l.fail(name, format, args...)
+	}
+}
+
+func (l *loader) str(name, def string) string {
+	if v := l.get(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func (l *loader) required(name string) string {
+	v := l.get(name)
+	l.check(v != "", name, "%s must be set", name)
+	return v
+}
+
+func (l *loader) oneOf(name, def string, options ...string) string {
+	v := l.str(name, def)
+	if !slices.Contains(options, v) {
+		l.fail(name, "%s must be one of %s, got %q", name, strings.Join(options, ", "), v)
+		return def
+	}
+	return v
+}
+
+// list splits a comma-separated setting, dropping empty items.
+func (l *loader) list(name string) []string {
+	return splitList(l.get(name))
+}
+
+func (l *loader) int(name string, def int) int {
+	v := l.get(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		l.fail(name, "%s must be a non-negative integer, got %q", name, v)
+		return def
+	}
+	return n
+}
+
+func (l *loader) float(name string, def float64) float64 {
+	v := l.get(name)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || !(f >= 0) || math.IsInf(f, 1) {
+		l.fail(name, "%s must be a non-negative number, got %q", name, v)
+		return def
+	}
+	return f
+}
+
+func (l *loader) bool(name string, def bool) bool {
+	v := l.get(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		l.fail(name, "%s must be true or false, got %q", name, v)
+		return def
+	}
+	return b
+}
+
+func (l *loader) duration(name string, def time.Duration) time.Duration {
+	v := l.get(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		l.fail(name, "%s must be a non-negative duration (e.g. 24h), got %q", name, v)
+		return def
+	}
+	return d
+}
+
+// prefixes parses a comma-separated list of CIDRs and addresses; ok is false
+// when it was reported as invalid.
+func (l *loader) prefixes(name, def string) ([]netip.Prefix, bool) {
+	prefixes, err := clientip.ParsePrefixes(l.str(name, def))
+	if err != nil {
+		l.fail(name, "%s must be a comma-separated list of CIDRs: %v", name, err)
+		return nil, false
+	}
+	return prefixes, true
+}
+
+// splitList splits a comma-separated value, dropping empty items.
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
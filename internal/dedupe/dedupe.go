@@ -0,0 +1,89 @@
+package dedupe
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"murmapp.hook/internal/cache"
+)
+
+// Store remembers recently published Telegram updates per webhook so that
+// redeliveries of the same update_id are not published twice.
+type Store interface {
+	// Add records the update and reports whether it had already been recorded.
+	Add(ctx context.Context, webhookID string, updateID int64) (bool, error)
+	// Remove forgets the update, e.g. when publishing it failed and Telegram
+	// must be allowed to redeliver it.
+	Remove(ctx context.Context, webhookID string, updateID int64) error
+}
+
+// MemoryStore keeps a bounded LRU window of update IDs per webhook. A
+// webhook's window is dropped once every update ID in it has expired.
+type MemoryStore struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu        sync.Mutex
+	webhooks  map[string]*window
+	lastSweep time.Time
+}
+
+type window struct {
+	ids *cache.LRU[int64, struct{}]
+	// lastAdd is when an update ID was last recorded; ttl later all of
+	// them have expired.
+	lastAdd time.Time
+}
+
+// NewMemoryStore keeps up to size update IDs per webhook for at most ttl.
+// A zero ttl keeps them, and the webhook's window, until evicted by size.
+func NewMemoryStore(size int, ttl time.Duration) *MemoryStore {
+	return &MemoryStore{
+		size:     size,
+		ttl:      ttl,
+		now:      time.Now,
+		webhooks: map[string]*window{},
+	}
+}
+
+func (s *MemoryStore) Add(ctx context.Context, webhookID string, updateID int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+	w, ok := s.webhooks[webhookID]
+	if !ok {
+		w = &window{ids: cache.NewLRU[int64, struct{}](s.size, s.ttl)}
+		s.webhooks[webhookID] = w
+	}
+	w.lastAdd = now
+	return w.ids.Add(updateID, struct{}{}), nil
+}
+
+func (s *MemoryStore) Remove(ctx context.Context, webhookID string, updateID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if w, ok := s.webhooks[webhookID]; ok {
+		w.ids.Remove(updateID)
+	}
+	return nil
+}
+
+// sweep drops the windows of webhooks that saw no update for ttl, at most
+// once per ttl, so bots that went quiet do not stay in memory. s.mu must
+// be held.
+func (s *MemoryStore) sweep(now time.Time) {
+	if s.ttl <= 0 || now.Sub(s.lastSweep) < s.ttl {
+		return
+	}
+	s.lastSweep = now
+	for id, w := range s.webhooks {
+		if now.Sub(w.lastAdd) > s.ttl {
+			delete(s.webhooks, id)
+		}
+	}
+}
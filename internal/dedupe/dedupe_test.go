@@ -0,0 +1,170 @@
+package dedupe_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"murmapp.hook/internal/dedupe"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := dedupe.NewMemoryStore(2, time.Hour)
+
+	dup, err := s.Add(ctx, "bot-a", 1)
+	require.NoError(t, err)
+	require.False(t, dup)
+
+	dup, _ = s.Add(ctx, "bot-a", 1)
+	require.True(t, dup)
+
+	// Windows are independent per webhook.
+	dup, _ = s.Add(ctx, "bot-b", 1)
+	require.False(t, dup)
+
+	// The window is bounded: update 1 falls out after two newer ones.
+	s.Add(ctx, "bot-a", 2)
+	s.Add(ctx, "bot-a", 3)
+	dup, _ = s.Add(ctx, "bot-a", 1)
+	require.False(t, dup)
+
+	require.NoError(t, s.Remove(ctx, "bot-a", 3))
+	dup, _ = s.Add(ctx, "bot-a", 3)
+	require.False(t, dup)
+}
+
+func TestRedisStore(t *testing.T) {
+	addr := fakeRedis(t)
+	s, err := dedupe.NewRedisStore("redis://:secret@"+addr+"/2", time.Minute, time.Second)
+	require.NoError(t, err)
+	defer s.Close()
+
+	ctx := context.Background()
+	dup, err := s.Add(ctx, "bot-a", 42)
+	require.NoError(t, err)
+	require.False(t, dup)
+
+	dup, err = s.Add(ctx, "bot-a", 42)
+	require.NoError(t, err)
+	require.True(t, dup)
+
+	require.NoError(t, s.Remove(ctx, "bot-a", 42))
+	dup, err = s.Add(ctx, "bot-a", 42)
+	require.NoError(t, err)
+	require.False(t, dup)
+}
+
+func TestRedisStore_hungServerTimesOut(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		// Accept connections and never answer.
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	s, err := dedupe.NewRedisStore("redis://"+ln.Addr().String(), time.Minute, 50*time.Millisecond)
+	require.NoError(t, err)
+	defer s.Close()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// No deadline on the context, as on the sync webhook path.
+			_, err := s.Add(context.Background(), "bot-a", int64(i))
+			require.Error(t, err)
+		}()
+	}
+	wg.Wait()
+	require.Less(t, time.Since(start), 500*time.Millisecond, "commands waiting behind a hung one give up with their own timeout")
+}
+
+func TestNewRedisStore_invalidURL(t *testing.T) {
+	_, err := dedupe.NewRedisStore("http://localhost:6379", time.Minute, 0)
+	require.Error(t, err)
+}
+
+// fakeRedis serves the handful of commands RedisStore uses.
+func fakeRedis(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	var mu sync.Mutex
+	keys := map[string]bool{}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rd := bufio.NewReader(conn)
+				for {
+					args, err := readCommand(rd)
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					switch strings.ToUpper(args[0]) {
+					case "AUTH", "SELECT":
+						fmt.Fprint(conn, "+OK\r\n")
+					case "SET":
+						if keys[args[1]] {
+							fmt.Fprint(conn, "$-1\r\n")
+						} else {
+							keys[args[1]] = true
+							fmt.Fprint(conn, "+OK\r\n")
+						}
+					case "DEL":
+						delete(keys, args[1])
+						fmt.Fprint(conn, ":1\r\n")
+					default:
+						fmt.Fprint(conn, "-ERR unknown command\r\n")
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func readCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		if _, err := rd.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, strings.TrimSuffix(arg, "\r\n"))
+	}
+	return args, nil
+}
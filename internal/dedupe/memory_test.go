@@ -0,0 +1,31 @@
+package dedupe
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_dropsIdleWindows(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	s := NewMemoryStore(10, time.Minute)
+	s.now = func() time.Time { return now }
+
+	_, err := s.Add(ctx, "bot-a", 1)
+	require.NoError(t, err)
+	require.NoError(t, s.Remove(ctx, "bot-c", 1))
+	require.Len(t, s.webhooks, 1, "Remove does not create a window")
+
+	now = now.Add(30 * time.Second)
+	_, err = s.Add(ctx, "bot-b", 1)
+	require.NoError(t, err)
+
+	now = now.Add(45 * time.Second)
+	_, err = s.Add(ctx, "bot-b", 2)
+	require.NoError(t, err)
+	require.Len(t, s.webhooks, 1, "bot-a saw nothing for longer than the TTL")
+	require.Contains(t, s.webhooks, "bot-b")
+}
@@ -0,0 +1,194 @@
+package dedupe
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RedisStore keeps update IDs in any server speaking the Redis protocol
+// (Redis, Valkey, KeyDB, Dragonfly), so several hook replicas share one
+// dedupe window. Keys expire after ttl.
+type RedisStore struct {
+	addr     string
+	password string
+	db       int
+	ttl      time.Duration
+	timeout  time.Duration
+	prefix   string
+
+	// sem guards the connection. Unlike a mutex, waiting for it gives up
+	// with the command's timeout, so a hung server cannot queue up every
+	// webhook request behind it.
+	sem  chan struct{}
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// DefaultRedisTimeout bounds each command when NewRedisStore is given none.
+const DefaultRedisTimeout = 200 * time.Millisecond
+
+// NewRedisStore parses redis://[:password@]host:port[/db]. The connection
+// is opened lazily and re-established after errors. Each command, including
+// waiting for the connection and dialing, takes at most timeout; the
+// connection is dropped when it runs out.
+func NewRedisStore(rawURL string, ttl, timeout time.Duration) (*RedisStore, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "redis" || u.Host == "" {
+		return nil, fmt.Errorf("invalid redis URL %q", rawURL)
+	}
+	if timeout <= 0 {
+		timeout = DefaultRedisTimeout
+	}
+
+	s := &RedisStore{addr: u.Host, ttl: ttl, timeout: timeout, prefix: "murmapp:hook:dedupe:", sem: make(chan struct{}, 1)}
+	if pw, ok := u.User.Password(); ok {
+		s.password = pw
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if s.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+	}
+	return s, nil
+}
+
+func (s *RedisStore) Add(ctx context.Context, webhookID string, updateID int64) (bool, error) {
+	reply, err := s.do(ctx, "SET", s.key(webhookID, updateID), "1", "NX", "PX", strconv.FormatInt(s.ttl.Milliseconds(), 10))
+	if err != nil {
+		return false, err
+	}
+	// SET NX answers OK when the key was created and a nil bulk string when it already existed.
+	return reply == "", nil
+}
+
+func (s *RedisStore) Remove(ctx context.Context, webhookID string, updateID int64) error {
+	_, err := s.do(ctx, "DEL", s.key(webhookID, updateID))
+	return err
+}
+
+// Close closes the underlying connection.
+func (s *RedisStore) Close() error {
+	s.sem <- struct{}{}
+	defer func() { <-s.sem }()
+	return s.reset()
+}
+
+func (s *RedisStore) key(webhookID string, updateID int64) string {
+	return s.prefix + webhookID + ":" + strconv.FormatInt(updateID, 10)
+}
+
+// do sends one command and returns its reply as a string; nil replies are "".
+func (s *RedisStore) do(ctx context.Context, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	select {
+	case s.sem <- struct{}{}:
+		defer func() { <-s.sem }()
+	case <-ctx.Done():
+		return "", fmt.Errorf("redis: waiting for connection: %w", ctx.Err())
+	}
+
+	if s.conn == nil {
+		if err := s.connect(ctx); err != nil {
+			return "", err
+		}
+	}
+	deadline, _ := ctx.Deadline()
+	_ = s.conn.SetDeadline(deadline)
+
+	reply, err := s.roundTrip(args...)
+	if err != nil {
+		_ = s.reset()
+	}
+	return reply, err
+}
+
+func (s *RedisStore) connect(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("redis dial: %w", err)
+	}
+	s.conn = conn
+	s.rd = bufio.NewReader(conn)
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+
+	if s.password != "" {
+		if _, err := s.roundTrip("AUTH", s.password); err != nil {
+			_ = s.reset()
+			return err
+		}
+	}
+	if s.db != 0 {
+		if _, err := s.roundTrip("SELECT", strconv.Itoa(s.db)); err != nil {
+			_ = s.reset()
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RedisStore) reset() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn, s.rd = nil, nil
+	return err
+}
+
+func (s *RedisStore) roundTrip(args ...string) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := s.conn.Write([]byte(b.String())); err != nil {
+		return "", fmt.Errorf("redis write: %w", err)
+	}
+	return readReply(s.rd)
+}
+
+// readReply parses the simple reply types the store relies on:
+// status, error, integer and bulk strings.
+func readReply(rd *bufio.Reader) (string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("redis read: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", fmt.Errorf("redis: %s", line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("redis: bad bulk length %q", line)
+		}
+		if n < 0 {
+			return "", nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return "", fmt.Errorf("redis read: %w", err)
+		}
+		return string(buf[:n]), nil
+	default:
+		return "", fmt.Errorf("redis: unsupported reply %q", line)
+	}
+}
@@ -0,0 +1,248 @@
+// Package envelope encrypts payloads under short-lived data keys that are
+// themselves encrypted with a long-lived key-encryption key (KEK). Only the
+// small wrapped data key depends on the KEK, so the KEK can be rotated while
+// consumers keep the old one around for payloads already in flight.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/eugene-ruby/xencryptor/xsecrets"
+	"murmapp.hook/internal/secret"
+)
+
+// Algorithms recorded next to the ciphertext.
+const (
+	// AlgorithmDirect is AES-256-GCM under the KEK itself, without a data key.
+	AlgorithmDirect = "A256GCM"
+	// AlgorithmEnvelope is AES-256-GCM under a random data key, which is
+	// wrapped with AES-256-GCM under the KEK.
+	AlgorithmEnvelope = "A256GCM+A256GCMKW"
+)
+
+const dataKeySize = 32
+
+// ErrUnknownKey is returned by Open when no KEK with the envelope's key ID
+// is known.
+var ErrUnknownKey = errors.New("unknown key-encryption key")
+
+// Envelope is a sealed payload and what is needed to open it.
+type Envelope struct {
+	Ciphertext []byte
+	// WrappedKey is the data key encrypted under the KEK; empty for
+	// AlgorithmDirect.
+	WrappedKey []byte
+	// KeyID identifies the KEK.
+	KeyID     string
+	Algorithm string
+}
+
+// Sealer encrypts payloads under the active key of a Keyring. It is safe
+// for concurrent use. A reused data key is held in a secret.Bytes: it is
+// zeroed when it is replaced and by Destroy, and Lock locks it into memory.
+type Sealer struct {
+	ring      *Keyring
+	algorithm string
+	// dataKeyTTL is how long a data key is reused; zero draws one per payload.
+	dataKeyTTL time.Duration
+
+	mu sync.Mutex
+	// dataKey is reused until expires, and only while keyID is active.
+	dataKey    *secret.Bytes
+	wrappedKey []byte
+	locked     bool
+	keyID      string
+	expires    time.Time
+	now        func() time.Time
+}
+
+// NewSealer returns a Sealer over ring. With AlgorithmEnvelope a data key is
+// reused for dataKeyTTL, or drawn for every payload when dataKeyTTL is zero.
+func NewSealer(ring *Keyring, algorithm string, dataKeyTTL time.Duration) (*Sealer, error) {
+	switch algorithm {
+	case AlgorithmDirect, AlgorithmEnvelope:
+	default:
+		return nil, fmt.Errorf("unsupported payload encryption algorithm %q", algorithm)
+	}
+	return &Sealer{
+		ring:       ring,
+		algorithm:  algorithm,
+		dataKeyTTL: dataKeyTTL,
+		now:        time.Now,
+	}, nil
+}
+
+// Seal encrypts plaintext under the keyring's active key and binds aad to
+// the ciphertext as associated data; Open must be given the same aad.
+func (s *Sealer) Seal(plaintext, aad []byte) (Envelope, error) {
+	kek, err := s.ring.Active()
+	if err != nil {
+		return Envelope{}, err
+	}
+	if s.algorithm == AlgorithmDirect || s.dataKeyTTL == 0 {
+		return SealWith(kek, s.algorithm, plaintext, aad)
+	}
+
+	gcm, wrapped, err := s.currentDataKey(kek)
+	if err != nil {
+		return Envelope{}, err
+	}
+	ct, err := sealWithGCM(gcm, plaintext, aad)
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{Ciphertext: ct, WrappedKey: wrapped, KeyID: kek.ID, Algorithm: s.algorithm}, nil
+}
+
+// Lock locks the reused data key into memory, now and whenever it is
+// replaced.
+func (s *Sealer) Lock() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.locked = true
+	return s.dataKey.Lock()
+}
+
+// Destroy zeroes the reused data key. Seal keeps working and draws a new
+// one.
+func (s *Sealer) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dataKey.Destroy()
+	s.dataKey, s.wrappedKey = nil, nil
+}
+
+// SealWith encrypts plaintext under kek without reusing data keys.
+func SealWith(kek Key, algorithm string, plaintext, aad []byte) (Envelope, error) {
+	env := Envelope{KeyID: kek.ID, Algorithm: algorithm}
+	key := kek.Material
+	switch algorithm {
+	case AlgorithmDirect:
+	case AlgorithmEnvelope:
+		dataKey, wrapped, err := newDataKey(kek)
+		if err != nil {
+			return Envelope{}, err
+		}
+		defer clear(dataKey)
+		key, env.WrappedKey = dataKey, wrapped
+	default:
+		return Envelope{}, fmt.Errorf("unsupported payload encryption algorithm %q", algorithm)
+	}
+
+	ct, err := sealGCM(key, plaintext, aad)
+	if err != nil {
+		return Envelope{}, err
+	}
+	env.Ciphertext = ct
+	return env, nil
+}
+
+// currentDataKey returns the AEAD of the data key to seal with and its
+// wrapped form, drawing a new key when the current one has expired or kek
+// has replaced the key it was wrapped with. The AEAD is built under s.mu, so
+// replacing the key never zeroes it under a caller.
+func (s *Sealer) currentDataKey(kek Key) (cipher.AEAD, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if s.dataKey == nil || s.keyID != kek.ID || !now.Before(s.expires) {
+		raw, wrapped, err := newDataKey(kek)
+		if err != nil {
+			return nil, nil, err
+		}
+		dataKey := secret.New(raw)
+		if s.locked {
+			if err := dataKey.Lock(); err != nil {
+				dataKey.Destroy()
+				return nil, nil, err
+			}
+		}
+		s.dataKey.Destroy()
+		s.dataKey, s.wrappedKey, s.keyID, s.expires = dataKey, wrapped, kek.ID, now.Add(s.dataKeyTTL)
+	}
+	gcm, err := newGCM(s.dataKey.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+	return gcm, s.wrappedKey, nil
+}
+
+func newDataKey(kek Key) ([]byte, []byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := xsecrets.EncryptBytesWithKey(dataKey, kek.Material)
+	if err != nil {
+		return nil, nil, fmt.Errorf("wrap data key: %w", err)
+	}
+	return dataKey, wrapped, nil
+}
+
+// sealGCM is xsecrets.EncryptBytesWithKey with associated data: the nonce
+// is prepended to the ciphertext. With empty aad both produce the same
+// format.
+func sealGCM(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return sealWithGCM(gcm, plaintext, aad)
+}
+
+func sealWithGCM(gcm cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func openGCM(key, ciphertext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ct := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ct, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Open decrypts env with the KEK named by its key ID and checks aad. keks
+// maps key IDs to KEKs, e.g. Keyring.Keys, so payloads sealed before a
+// rotation still open.
+func Open(env Envelope, keks map[string][]byte, aad []byte) ([]byte, error) {
+	kek, ok := keks[env.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, env.KeyID)
+	}
+
+	switch env.Algorithm {
+	case AlgorithmDirect:
+		return openGCM(kek, env.Ciphertext, aad)
+	case AlgorithmEnvelope:
+		dataKey, err := xsecrets.DecryptBytesWithKey(env.WrappedKey, kek)
+		if err != nil {
+			return nil, fmt.Errorf("unwrap data key: %w", err)
+		}
+		return openGCM(dataKey, env.Ciphertext, aad)
+	default:
+		return nil, fmt.Errorf("unsupported payload encryption algorithm %q", env.Algorithm)
+	}
+}
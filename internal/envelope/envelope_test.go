@@ -0,0 +1,135 @@
+package envelope
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	kek    = []byte("payload-32-byte-key-abc123456789")
+	oldKEK = []byte("previous-32-byte-key-abc12345678")
+)
+
+func testRing(t *testing.T) *Keyring {
+	t.Helper()
+	ring, err := NewKeyring("k1", []Key{{ID: "k1", Material: kek}})
+	require.NoError(t, err)
+	return ring
+}
+
+func TestSealer_envelope(t *testing.T) {
+	s, err := NewSealer(testRing(t), AlgorithmEnvelope, 0)
+	require.NoError(t, err)
+
+	a, err := s.Seal([]byte(`{"update_id":1}`), nil)
+	require.NoError(t, err)
+	require.Equal(t, "k1", a.KeyID)
+	require.Equal(t, AlgorithmEnvelope, a.Algorithm)
+	require.NotEmpty(t, a.WrappedKey)
+
+	b, err := s.Seal([]byte(`{"update_id":2}`), nil)
+	require.NoError(t, err)
+	require.False(t, bytes.Equal(a.WrappedKey, b.WrappedKey), "a data key is drawn per payload")
+
+	got, err := Open(a, map[string][]byte{"k0": oldKEK, "k1": kek}, nil)
+	require.NoError(t, err)
+	require.Equal(t, `{"update_id":1}`, string(got))
+
+	_, err = Open(a, map[string][]byte{"k0": oldKEK}, nil)
+	require.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestSealer_dataKeyTTL(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s, err := NewSealer(testRing(t), AlgorithmEnvelope, time.Minute)
+	require.NoError(t, err)
+	s.now = func() time.Time { return now }
+
+	a, err := s.Seal([]byte("a"), nil)
+	require.NoError(t, err)
+	b, err := s.Seal([]byte("b"), nil)
+	require.NoError(t, err)
+	require.Equal(t, a.WrappedKey, b.WrappedKey)
+	first := s.dataKey.Bytes()
+
+	now = now.Add(time.Minute)
+	c, err := s.Seal([]byte("c"), nil)
+	require.NoError(t, err)
+	require.NotEqual(t, a.WrappedKey, c.WrappedKey)
+	require.Equal(t, make([]byte, dataKeySize), first, "the replaced data key is zeroed")
+
+	current := s.dataKey.Bytes()
+	s.Destroy()
+	require.Equal(t, make([]byte, dataKeySize), current, "Destroy zeroes the data key")
+	d, err := s.Seal([]byte("d"), nil)
+	require.NoError(t, err)
+	require.NotEqual(t, c.WrappedKey, d.WrappedKey, "a new data key is drawn after Destroy")
+
+	got, err := Open(b, map[string][]byte{"k1": kek}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "b", string(got))
+}
+
+func TestSealer_direct(t *testing.T) {
+	s, err := NewSealer(testRing(t), AlgorithmDirect, 0)
+	require.NoError(t, err)
+
+	env, err := s.Seal([]byte("a"), nil)
+	require.NoError(t, err)
+	require.Empty(t, env.WrappedKey)
+
+	got, err := Open(env, map[string][]byte{"k1": kek}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "a", string(got))
+}
+
+func TestSealer_followsActiveKey(t *testing.T) {
+	ring := testRing(t)
+	s, err := NewSealer(ring, AlgorithmEnvelope, time.Hour)
+	require.NoError(t, err)
+
+	a, err := s.Seal([]byte("a"), nil)
+	require.NoError(t, err)
+	require.Equal(t, "k1", a.KeyID)
+
+	require.NoError(t, ring.Set("k2", []Key{{ID: "k1", Material: kek}, {ID: "k2", Material: oldKEK}}))
+	b, err := s.Seal([]byte("b"), nil)
+	require.NoError(t, err)
+	require.Equal(t, "k2", b.KeyID)
+	require.NotEqual(t, a.WrappedKey, b.WrappedKey, "the data key is redrawn under the new key")
+
+	for _, env := range []Envelope{a, b} {
+		_, err := Open(env, ring.Keys(), nil)
+		require.NoError(t, err)
+	}
+}
+
+func TestNewSealer_invalid(t *testing.T) {
+	_, err := NewSealer(testRing(t), "ROT13", 0)
+	require.ErrorContains(t, err, "unsupported")
+}
+
+func TestSealer_associatedData(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmEnvelope, AlgorithmDirect} {
+		t.Run(algorithm, func(t *testing.T) {
+			s, err := NewSealer(testRing(t), algorithm, time.Minute)
+			require.NoError(t, err)
+			keks := map[string][]byte{"k1": kek}
+
+			env, err := s.Seal([]byte("payload"), []byte("webhook-a"))
+			require.NoError(t, err)
+
+			got, err := Open(env, keks, []byte("webhook-a"))
+			require.NoError(t, err)
+			require.Equal(t, "payload", string(got))
+
+			_, err = Open(env, keks, []byte("webhook-b"))
+			require.Error(t, err, "a ciphertext must not open under other metadata")
+			_, err = Open(env, keks, nil)
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package envelope
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"murmapp.hook/internal/secret"
+)
+
+// retireDelay is how long key material replaced by Set is kept before it is
+// zeroed, so a seal or open that picked it up just before still completes.
+const retireDelay = time.Minute
+
+// ErrKeyNotValid is returned when a keyring is set with an active key outside
+// its validity window.
+var ErrKeyNotValid = errors.New("active key-encryption key is outside its validity window")
+
+// Key is a key-encryption key. A zero NotBefore or NotAfter leaves that end
+// of the validity window open.
+type Key struct {
+	ID        string
+	Material  []byte
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// ValidAt reports whether t falls within the key's validity window.
+func (k Key) ValidAt(t time.Time) bool {
+	if !k.NotBefore.IsZero() && t.Before(k.NotBefore) {
+		return false
+	}
+	return k.NotAfter.IsZero() || t.Before(k.NotAfter)
+}
+
+// Keyring holds the active key-encryption key, which seals new payloads,
+// and retired keys that are kept so older payloads can still be opened. Its
+// contents can be replaced at runtime with Set; it is safe for concurrent use.
+//
+// The keyring holds its own copy of the key material in secret.Bytes: Lock
+// locks it into memory and Destroy zeroes it.
+type Keyring struct {
+	mu      sync.RWMutex
+	active  Key
+	keys    map[string]Key
+	secrets []*secret.Bytes
+	locked  bool
+	now     func() time.Time
+
+	// warned is the fallback key last logged by Active.
+	warnMu sync.Mutex
+	warned string
+}
+
+// NewKeyring returns a keyring whose active key is the key with ID active.
+func NewKeyring(active string, keys []Key) (*Keyring, error) {
+	k := &Keyring{now: time.Now}
+	if err := k.Set(active, keys); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Set validates keys and replaces the keyring's contents with a copy of
+// them; the caller may zero its own material afterwards. The replaced
+// material is zeroed after retireDelay. On error the keyring is left
+// unchanged.
+func (k *Keyring) Set(active string, keys []Key) error {
+	byID := make(map[string]Key, len(keys))
+	for _, key := range keys {
+		if key.ID == "" {
+			return fmt.Errorf("keyring: key without id")
+		}
+		if _, dup := byID[key.ID]; dup {
+			return fmt.Errorf("keyring: duplicate key id %q", key.ID)
+		}
+		if len(key.Material) != dataKeySize {
+			return fmt.Errorf("keyring: key %q must be %d bytes, got %d", key.ID, dataKeySize, len(key.Material))
+		}
+		if !key.NotAfter.IsZero() && !key.NotAfter.After(key.NotBefore) {
+			return fmt.Errorf("keyring: key %q must have not_after after not_before", key.ID)
+		}
+		byID[key.ID] = key
+	}
+
+	activeKey, ok := byID[active]
+	if !ok {
+		return fmt.Errorf("keyring: active key %q not found", active)
+	}
+	if !activeKey.ValidAt(k.now()) {
+		return fmt.Errorf("keyring: active key %q: %w", active, ErrKeyNotValid)
+	}
+
+	k.mu.Lock()
+	secrets := make([]*secret.Bytes, 0, len(byID))
+	for id, key := range byID {
+		s := secret.New(bytes.Clone(key.Material))
+		secrets = append(secrets, s)
+		if k.locked {
+			if err := s.Lock(); err != nil {
+				k.mu.Unlock()
+				destroyAll(secrets)
+				return fmt.Errorf("keyring: key %q: %w", id, err)
+			}
+		}
+		key.Material = s.Bytes()
+		byID[id] = key
+	}
+	retired := k.secrets
+	k.active, k.keys, k.secrets = byID[active], byID, secrets
+	k.mu.Unlock()
+	if len(retired) > 0 {
+		time.AfterFunc(retireDelay, func() { destroyAll(retired) })
+	}
+
+	k.warnMu.Lock()
+	k.warned = ""
+	k.warnMu.Unlock()
+	return nil
+}
+
+// Active returns the key new payloads are sealed with. Once the active key's
+// window closes, the newest key still within its window takes over; with none
+// left the expired key keeps sealing, so an expiry date is never an outage.
+// Either is logged once, as the keyring needs a new active key.
+func (k *Keyring) Active() (Key, error) {
+	k.mu.RLock()
+	key, fallback := k.sealingKey(k.now())
+	active := k.active.ID
+	k.mu.RUnlock()
+	if key.ID == "" {
+		return Key{}, errors.New("keyring: no active key")
+	}
+
+	if fallback {
+		k.warnOnce(active, key.ID)
+	}
+	return key, nil
+}
+
+// ExpiresAt is the end of the validity window of the key Active returns; zero
+// means it does not expire.
+func (k *Keyring) ExpiresAt() time.Time {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, _ := k.sealingKey(k.now())
+	return key.NotAfter
+}
+
+// sealingKey picks the key to seal with at now and reports whether it is not
+// the configured active key in its window. Callers hold k.mu.
+func (k *Keyring) sealingKey(now time.Time) (Key, bool) {
+	if k.active.ValidAt(now) {
+		return k.active, false
+	}
+	var newest Key
+	for _, key := range k.keys {
+		if key.ValidAt(now) && (newest.ID == "" || key.NotBefore.After(newest.NotBefore) ||
+			key.NotBefore.Equal(newest.NotBefore) && key.ID > newest.ID) {
+			newest = key
+		}
+	}
+	if newest.ID == "" {
+		return k.active, true
+	}
+	return newest, true
+}
+
+func (k *Keyring) warnOnce(active, sealing string) {
+	k.warnMu.Lock()
+	defer k.warnMu.Unlock()
+	if k.warned == sealing {
+		return
+	}
+	k.warned = sealing
+	if sealing == active {
+		log.Printf("[keyring] ❌ active key %q expired and no other key is valid, still sealing with it: set a new active key", active)
+	} else {
+		log.Printf("[keyring] ⚠️ active key %q expired, sealing with %q: set a new active key", active, sealing)
+	}
+}
+
+// Lock locks the key material into memory, now and after every Set.
+func (k *Keyring) Lock() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, s := range k.secrets {
+		if err := s.Lock(); err != nil {
+			return err
+		}
+	}
+	k.locked = true
+	return nil
+}
+
+// Destroy zeroes the key material. Nothing may seal or open with the
+// keyring afterwards.
+func (k *Keyring) Destroy() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	destroyAll(k.secrets)
+}
+
+func destroyAll(secrets []*secret.Bytes) {
+	for _, s := range secrets {
+		s.Destroy()
+	}
+}
+
+// Keys returns the material of every key still within its validity window by
+// ID, in the form Open expects, plus the key Active seals with.
+func (k *Keyring) Keys() map[string][]byte {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	now := k.now()
+	out := make(map[string][]byte, len(k.keys))
+	for id, key := range k.keys {
+		if key.ValidAt(now) {
+			out[id] = key.Material
+		}
+	}
+	if sealing, _ := k.sealingKey(now); sealing.ID != "" {
+		out[sealing.ID] = sealing.Material
+	}
+	return out
+}
@@ -0,0 +1,97 @@
+package envelope
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyring_set(t *testing.T) {
+	now := time.Unix(1000, 0)
+	ring, err := NewKeyring("k1", []Key{{ID: "k1", Material: kek}})
+	require.NoError(t, err)
+	ring.now = func() time.Time { return now }
+
+	for name, tc := range map[string]struct {
+		active string
+		keys   []Key
+		err    string
+	}{
+		"unknown active": {"k9", []Key{{ID: "k1", Material: kek}}, "not found"},
+		"duplicate":      {"k1", []Key{{ID: "k1", Material: kek}, {ID: "k1", Material: oldKEK}}, "duplicate"},
+		"short key":      {"k1", []Key{{ID: "k1", Material: kek[:16]}}, "32 bytes"},
+		"not yet valid":  {"k1", []Key{{ID: "k1", Material: kek, NotBefore: now.Add(time.Hour)}}, "validity window"},
+		"empty window":   {"k1", []Key{{ID: "k1", Material: kek, NotBefore: now, NotAfter: now}}, "not_after"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.ErrorContains(t, ring.Set(tc.active, tc.keys), tc.err)
+			active, err := ring.Active()
+			require.NoError(t, err)
+			require.Equal(t, "k1", active.ID, "a rejected set leaves the keyring unchanged")
+		})
+	}
+}
+
+func TestKeyring_validity(t *testing.T) {
+	now := time.Unix(1000, 0)
+	ring := &Keyring{now: func() time.Time { return now }}
+	require.NoError(t, ring.Set("new", []Key{
+		{ID: "new", Material: kek, NotBefore: now, NotAfter: now.Add(time.Hour)},
+		{ID: "old", Material: oldKEK, NotAfter: now.Add(time.Minute)},
+	}))
+	require.Len(t, ring.Keys(), 2)
+
+	// Retired keys drop out once their window closes.
+	now = now.Add(time.Minute)
+	require.Equal(t, []string{"new"}, keys(ring.Keys()))
+
+	// With no valid key left the expired active key keeps sealing, so an
+	// expiry date is not an outage.
+	now = now.Add(time.Hour)
+	active, err := ring.Active()
+	require.NoError(t, err)
+	require.Equal(t, "new", active.ID)
+	require.Contains(t, ring.Keys(), "new", "payloads sealed with it can still be opened")
+	require.True(t, ring.ExpiresAt().Before(now), "the expiry stays visible once it has passed")
+}
+
+func TestKeyring_fallsBackToNewestValidKey(t *testing.T) {
+	now := time.Unix(1000, 0)
+	ring := &Keyring{now: func() time.Time { return now }}
+	require.NoError(t, ring.Set("current", []Key{
+		{ID: "current", Material: kek, NotAfter: now.Add(time.Hour)},
+		{ID: "next", Material: oldKEK, NotBefore: now.Add(30 * time.Minute)},
+		{ID: "older", Material: oldKEK},
+	}))
+	require.Equal(t, now.Add(time.Hour), ring.ExpiresAt())
+
+	now = now.Add(2 * time.Hour)
+	active, err := ring.Active()
+	require.NoError(t, err)
+	require.Equal(t, "next", active.ID, "the newest key within its window seals once the active key expires")
+	require.True(t, ring.ExpiresAt().IsZero())
+}
+
+func TestKeyring_ownsMaterial(t *testing.T) {
+	material := bytes.Clone(kek)
+	ring, err := NewKeyring("k1", []Key{{ID: "k1", Material: material}})
+	require.NoError(t, err)
+
+	clear(material)
+	active, err := ring.Active()
+	require.NoError(t, err)
+	require.Equal(t, kek, active.Material, "the keyring keeps its own copy")
+
+	ring.Destroy()
+	require.Equal(t, make([]byte, len(kek)), active.Material, "Destroy zeroes the material in place")
+}
+
+func keys(m map[string][]byte) []string {
+	var out []string
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
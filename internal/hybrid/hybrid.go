@@ -0,0 +1,248 @@
+// Package hybrid encrypts Telegram IDs for the caster. The original scheme
+// spends one RSA-OAEP operation per ID; the X25519 scheme replaces it with
+// an ephemeral Diffie-Hellman exchange and AES-GCM, which keeps the
+// ciphertext small and, with the exchange reused for a short window, costs
+// little more than the AES-GCM seal per ID.
+package hybrid
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/eugene-ruby/xencryptor/xsecrets"
+	"murmapp.hook/internal/secret"
+)
+
+// Versions recorded in EncryptedTelegramID.version.
+const (
+	// VersionRSA is RSA-OAEP-SHA256 of the ID under the caster's RSA key.
+	VersionRSA uint32 = 0
+	// VersionX25519 is AES-256-GCM under a key derived with HKDF-SHA256 from
+	// an X25519 exchange between a fresh ephemeral key and the caster's key.
+	VersionX25519 uint32 = 1
+)
+
+// hkdfInfo separates keys derived for Telegram IDs from any other use of
+// the caster's X25519 key.
+const hkdfInfo = "murmapp.hook EncryptedTelegramID v1"
+
+// Sealed is an encrypted ID and what the caster needs to decrypt it.
+type Sealed struct {
+	Version    uint32
+	Ciphertext []byte
+	// EphemeralPublicKey is the sender's X25519 public key; empty for RSA.
+	EphemeralPublicKey []byte
+	// KeyID identifies the caster key the ID was encrypted for.
+	KeyID string
+}
+
+// Encrypter encrypts IDs for one caster key. aad is bound to the
+// ciphertext where the scheme supports it (not RSA).
+type Encrypter interface {
+	Encrypt(plaintext, aad []byte) (Sealed, error)
+}
+
+// RSAEncrypter is the original scheme, kept for casters that have not
+// moved to X25519.
+type RSAEncrypter struct {
+	Key   *rsa.PublicKey
+	KeyID string
+}
+
+func (e *RSAEncrypter) Encrypt(plaintext, _ []byte) (Sealed, error) {
+	ct, err := xsecrets.RSAEncryptBytes(e.Key, plaintext)
+	if err != nil {
+		return Sealed{}, err
+	}
+	return Sealed{Version: VersionRSA, Ciphertext: ct, KeyID: e.KeyID}, nil
+}
+
+// X25519Encrypter encrypts for the caster's X25519 public key. The
+// ephemeral exchange costs two scalar multiplications, more than an RSA
+// public-key operation, so it can be reused for a short window and each ID
+// then only costs an AES-GCM seal. IDs sealed within one window share the
+// ephemeral key and can be linked to each other by it.
+//
+// The key derived from a reused exchange is held in a secret.Bytes: it is
+// zeroed when the exchange is replaced and by Destroy, and Lock locks it
+// into memory.
+type X25519Encrypter struct {
+	key   *ecdh.PublicKey
+	keyID string
+	// ephemeralTTL is how long an ephemeral key is reused; zero draws one
+	// per ID.
+	ephemeralTTL time.Duration
+
+	mu           sync.Mutex
+	ephemeralPub []byte
+	sharedKey    *secret.Bytes
+	expires      time.Time
+	locked       bool
+	// destroyed stops reuse: every ID then gets its own exchange.
+	destroyed bool
+	now       func() time.Time
+}
+
+// NewX25519Encrypter returns an encrypter for key, identified by keyID.
+func NewX25519Encrypter(key *ecdh.PublicKey, keyID string, ephemeralTTL time.Duration) *X25519Encrypter {
+	return &X25519Encrypter{key: key, keyID: keyID, ephemeralTTL: ephemeralTTL, now: time.Now}
+}
+
+func (e *X25519Encrypter) Encrypt(plaintext, aad []byte) (Sealed, error) {
+	ephemeralPub, gcm, err := e.exchange()
+	if err != nil {
+		return Sealed{}, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return Sealed{}, err
+	}
+	return Sealed{
+		Version:            VersionX25519,
+		Ciphertext:         gcm.Seal(nonce, nonce, plaintext, aad),
+		EphemeralPublicKey: ephemeralPub,
+		KeyID:              e.keyID,
+	}, nil
+}
+
+// Lock locks the derived key into memory, now and for every new exchange.
+// Before the first exchange there is no key yet; it is locked when created.
+func (e *X25519Encrypter) Lock() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.locked = true
+	if e.sharedKey == nil {
+		return nil
+	}
+	return e.sharedKey.Lock()
+}
+
+// Destroy zeroes the derived key. Encrypt keeps working but no longer
+// reuses exchanges.
+func (e *X25519Encrypter) Destroy() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sharedKey.Destroy()
+	e.sharedKey, e.ephemeralPub, e.destroyed = nil, nil, true
+}
+
+// exchange returns the ephemeral public key and the AEAD derived from it,
+// running a new exchange when the current one has expired.
+func (e *X25519Encrypter) exchange() ([]byte, cipher.AEAD, error) {
+	if e.ephemeralTTL == 0 {
+		return e.oneOffExchange()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.destroyed {
+		return e.oneOffExchange()
+	}
+	now := e.now()
+	if e.sharedKey == nil || !now.Before(e.expires) {
+		pub, key, err := e.newExchange()
+		if err != nil {
+			return nil, nil, err
+		}
+		sharedKey := secret.New(key)
+		if e.locked {
+			if err := sharedKey.Lock(); err != nil {
+				sharedKey.Destroy()
+				return nil, nil, err
+			}
+		}
+		e.sharedKey.Destroy()
+		e.ephemeralPub, e.sharedKey, e.expires = pub, sharedKey, now.Add(e.ephemeralTTL)
+	}
+	// The AEAD is built under e.mu, so replacing the key never zeroes it
+	// under a caller.
+	gcm, err := newGCM(e.sharedKey.Bytes())
+	return e.ephemeralPub, gcm, err
+}
+
+// oneOffExchange runs an exchange for a single ID and zeroes its key once
+// the AEAD is built.
+func (e *X25519Encrypter) oneOffExchange() ([]byte, cipher.AEAD, error) {
+	pub, key, err := e.newExchange()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer clear(key)
+	gcm, err := newGCM(key)
+	return pub, gcm, err
+}
+
+// newExchange runs an exchange with a fresh ephemeral key and returns its
+// public half and the derived AES key.
+func (e *X25519Encrypter) newExchange() ([]byte, []byte, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	shared, err := ephemeral.ECDH(e.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer clear(shared)
+	pub := ephemeral.PublicKey().Bytes()
+	key, err := deriveKey(shared, pub, e.key.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+	return pub, key, nil
+}
+
+// OpenX25519 is the caster side of X25519Encrypter.
+func OpenX25519(priv *ecdh.PrivateKey, s Sealed, aad []byte) ([]byte, error) {
+	if s.Version != VersionX25519 {
+		return nil, fmt.Errorf("unsupported EncryptedTelegramID version %d", s.Version)
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(s.EphemeralPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("ephemeral public key: %w", err)
+	}
+	shared, err := priv.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := deriveKey(shared, s.EphemeralPublicKey, priv.PublicKey().Bytes())
+	clear(shared)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	clear(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ct := s.Ciphertext[:gcm.NonceSize()], s.Ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ct, aad)
+}
+
+// deriveKey derives the AES-256-GCM key from the shared secret, salted with
+// both public keys so the key is tied to this exchange.
+func deriveKey(shared, ephemeralPub, recipientPub []byte) ([]byte, error) {
+	salt := append(append([]byte{}, ephemeralPub...), recipientPub...)
+	return hkdf.Key(sha256.New, shared, salt, hkdfInfo, 32)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
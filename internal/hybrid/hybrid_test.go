@@ -0,0 +1,153 @@
+package hybrid
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/eugene-ruby/xencryptor/xsecrets"
+	"github.com/stretchr/testify/require"
+)
+
+func TestX25519_roundTrip(t *testing.T) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	e := NewX25519Encrypter(priv.PublicKey(), "caster-1", 0)
+
+	a, err := e.Encrypt([]byte("123456789"), []byte("xid-a"))
+	require.NoError(t, err)
+	require.Equal(t, VersionX25519, a.Version)
+	require.Equal(t, "caster-1", a.KeyID)
+	require.Len(t, a.EphemeralPublicKey, 32)
+
+	got, err := OpenX25519(priv, a, []byte("xid-a"))
+	require.NoError(t, err)
+	require.Equal(t, "123456789", string(got))
+
+	_, err = OpenX25519(priv, a, []byte("xid-b"))
+	require.Error(t, err, "the ciphertext is bound to its XID")
+
+	b, err := e.Encrypt([]byte("123456789"), []byte("xid-a"))
+	require.NoError(t, err)
+	require.NotEqual(t, a.EphemeralPublicKey, b.EphemeralPublicKey, "every ID gets a fresh ephemeral key")
+
+	other, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, err = OpenX25519(other, a, []byte("xid-a"))
+	require.Error(t, err)
+}
+
+func TestX25519_ephemeralTTL(t *testing.T) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	now := time.Unix(1000, 0)
+	e := NewX25519Encrypter(priv.PublicKey(), "caster-1", time.Minute)
+	e.now = func() time.Time { return now }
+
+	a, err := e.Encrypt([]byte("1"), []byte("xid-1"))
+	require.NoError(t, err)
+	b, err := e.Encrypt([]byte("2"), []byte("xid-2"))
+	require.NoError(t, err)
+	require.Equal(t, a.EphemeralPublicKey, b.EphemeralPublicKey)
+	require.NotEqual(t, a.Ciphertext[:12], b.Ciphertext[:12], "nonces differ under a shared key")
+
+	now = now.Add(time.Minute)
+	c, err := e.Encrypt([]byte("3"), []byte("xid-3"))
+	require.NoError(t, err)
+	require.NotEqual(t, a.EphemeralPublicKey, c.EphemeralPublicKey)
+
+	for i, s := range []Sealed{a, b, c} {
+		got, err := OpenX25519(priv, s, []byte(fmt.Sprintf("xid-%d", i+1)))
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprint(i+1), string(got))
+	}
+}
+
+func TestX25519_lockBeforeFirstExchange(t *testing.T) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	e := NewX25519Encrypter(priv.PublicKey(), "caster-1", time.Minute)
+
+	require.NoError(t, e.Lock(), "there is no key to lock yet")
+	_, err = e.Encrypt([]byte("1"), nil)
+	if err != nil {
+		require.ErrorContains(t, err, "mlock")
+		t.Skipf("mlock unavailable here: %v", err)
+	}
+	require.NotNil(t, e.sharedKey, "the first exchange's key is created and locked")
+}
+
+func TestX25519_destroy(t *testing.T) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	e := NewX25519Encrypter(priv.PublicKey(), "caster-1", time.Minute)
+
+	a, err := e.Encrypt([]byte("1"), nil)
+	require.NoError(t, err)
+	key := e.sharedKey.Bytes()
+
+	e.Destroy()
+	require.Equal(t, make([]byte, len(key)), key, "the derived key is zeroed")
+
+	b, err := e.Encrypt([]byte("2"), nil)
+	require.NoError(t, err)
+	c, err := e.Encrypt([]byte("3"), nil)
+	require.NoError(t, err)
+	require.NotEqual(t, a.EphemeralPublicKey, b.EphemeralPublicKey)
+	require.NotEqual(t, b.EphemeralPublicKey, c.EphemeralPublicKey, "no exchange is reused after Destroy")
+	require.Nil(t, e.sharedKey)
+
+	got, err := OpenX25519(priv, c, nil)
+	require.NoError(t, err)
+	require.Equal(t, "3", string(got))
+}
+
+func TestRSA_roundTrip(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	e := &RSAEncrypter{Key: &priv.PublicKey, KeyID: "rsa-1"}
+
+	s, err := e.Encrypt([]byte("123456789"), []byte("ignored"))
+	require.NoError(t, err)
+	require.Equal(t, VersionRSA, s.Version)
+	require.Empty(t, s.EphemeralPublicKey)
+
+	got, err := xsecrets.RSADecryptBytes(s.Ciphertext, priv)
+	require.NoError(t, err)
+	require.Equal(t, "123456789", string(got))
+}
+
+// BenchmarkEncrypt compares the per-ID cost of the RSA-2048 path with the
+// X25519 hybrid scheme, with an ephemeral key per ID and reused for a window.
+func BenchmarkEncrypt(b *testing.B) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(b, err)
+	x25519Key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(b, err)
+
+	id := []byte("5123456789")
+	aad := []byte("3155b66fa12f59c373773dd79658f85d93baa739fb1025dd67641ce1d4042a21")
+	for _, bc := range []struct {
+		name string
+		e    Encrypter
+	}{
+		{"rsa-2048", &RSAEncrypter{Key: &rsaKey.PublicKey}},
+		{"x25519", NewX25519Encrypter(x25519Key.PublicKey(), "", 0)},
+		{"x25519-reused", NewX25519Encrypter(x25519Key.PublicKey(), "", time.Minute)},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			var size int
+			for i := 0; i < b.N; i++ {
+				s, err := bc.e.Encrypt(id, aad)
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(s.Ciphertext) + len(s.EphemeralPublicKey)
+			}
+			b.ReportMetric(float64(size), "bytes/id")
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package hybrid
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Recipient is one key Telegram IDs are encrypted for.
+type Recipient struct {
+	KeyID     string
+	Encrypter Encrypter
+}
+
+// Recipients is the set of keys every Telegram ID is encrypted for, e.g. the
+// current and next caster key, or the caster and an audit service. Its
+// contents can be replaced at runtime with Set; it is safe for concurrent use.
+type Recipients struct {
+	mu         sync.RWMutex
+	recipients []Recipient
+	locked     bool
+}
+
+// secretHolder is implemented by encrypters that keep key material, such as
+// X25519Encrypter.
+type secretHolder interface {
+	Lock() error
+	Destroy()
+}
+
+// NewRecipients returns a set holding recipients.
+func NewRecipients(recipients ...Recipient) (*Recipients, error) {
+	r := &Recipients{}
+	if err := r.Set(recipients); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Set validates recipients and replaces the set. The key material of the
+// replaced encrypters is zeroed. On error the set is left unchanged.
+func (r *Recipients) Set(recipients []Recipient) error {
+	if len(recipients) == 0 {
+		return errors.New("recipients: at least one recipient key is required")
+	}
+	seen := make(map[string]bool, len(recipients))
+	for _, rc := range recipients {
+		if rc.KeyID == "" {
+			return errors.New("recipients: key without id")
+		}
+		if seen[rc.KeyID] {
+			return fmt.Errorf("recipients: duplicate key id %q", rc.KeyID)
+		}
+		seen[rc.KeyID] = true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.locked {
+		if err := lockAll(recipients); err != nil {
+			return err
+		}
+	}
+	retired := r.recipients
+	r.recipients = append([]Recipient(nil), recipients...)
+	for _, rc := range retired {
+		if h, ok := rc.Encrypter.(secretHolder); ok && !slices.ContainsFunc(recipients, func(n Recipient) bool { return n.Encrypter == rc.Encrypter }) {
+			h.Destroy()
+		}
+	}
+	return nil
+}
+
+// Lock locks the recipients' key material into memory, now and after every
+// Set.
+func (r *Recipients) Lock() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := lockAll(r.recipients); err != nil {
+		return err
+	}
+	r.locked = true
+	return nil
+}
+
+// Destroy zeroes the recipients' key material.
+func (r *Recipients) Destroy() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rc := range r.recipients {
+		if h, ok := rc.Encrypter.(secretHolder); ok {
+			h.Destroy()
+		}
+	}
+}
+
+func lockAll(recipients []Recipient) error {
+	for _, rc := range recipients {
+		if h, ok := rc.Encrypter.(secretHolder); ok {
+			if err := h.Lock(); err != nil {
+				return fmt.Errorf("recipients: key %q: %w", rc.KeyID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// KeyIDs returns the IDs of the recipient keys in order.
+func (r *Recipients) KeyIDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := make([]string, len(r.recipients))
+	for i, rc := range r.recipients {
+		ids[i] = rc.KeyID
+	}
+	return ids
+}
+
+// Encrypt encrypts plaintext for every recipient, in order. It fails if any
+// recipient fails, so no recipient silently misses an ID.
+func (r *Recipients) Encrypt(plaintext, aad []byte) ([]Sealed, error) {
+	r.mu.RLock()
+	recipients := r.recipients
+	r.mu.RUnlock()
+
+	sealed := make([]Sealed, 0, len(recipients))
+	for _, rc := range recipients {
+		s, err := rc.Encrypter.Encrypt(plaintext, aad)
+		if err != nil {
+			return nil, fmt.Errorf("recipient %q: %w", rc.KeyID, err)
+		}
+		s.KeyID = rc.KeyID
+		sealed = append(sealed, s)
+	}
+	return sealed, nil
+}
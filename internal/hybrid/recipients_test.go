@@ -0,0 +1,64 @@
+package hybrid
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/eugene-ruby/xencryptor/xsecrets"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecipients_encryptsForEach(t *testing.T) {
+	caster, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	audit, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	r, err := NewRecipients(
+		Recipient{KeyID: "caster", Encrypter: NewX25519Encrypter(caster.PublicKey(), "", 0)},
+		Recipient{KeyID: "audit", Encrypter: &RSAEncrypter{Key: &audit.PublicKey}},
+	)
+	require.NoError(t, err)
+	require.Equal(t, []string{"caster", "audit"}, r.KeyIDs())
+
+	sealed, err := r.Encrypt([]byte("42"), []byte("xid"))
+	require.NoError(t, err)
+	require.Len(t, sealed, 2)
+	require.Equal(t, "caster", sealed[0].KeyID, "the recipient's ID tags its ciphertext")
+	require.Equal(t, "audit", sealed[1].KeyID)
+
+	got, err := OpenX25519(caster, sealed[0], []byte("xid"))
+	require.NoError(t, err)
+	require.Equal(t, "42", string(got))
+	got, err = xsecrets.RSADecryptBytes(sealed[1].Ciphertext, audit)
+	require.NoError(t, err)
+	require.Equal(t, "42", string(got))
+}
+
+func TestRecipients_set(t *testing.T) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	enc := NewX25519Encrypter(priv.PublicKey(), "", 0)
+
+	r, err := NewRecipients(Recipient{KeyID: "k1", Encrypter: enc})
+	require.NoError(t, err)
+
+	require.ErrorContains(t, r.Set(nil), "at least one")
+	require.ErrorContains(t, r.Set([]Recipient{{KeyID: "k1", Encrypter: enc}, {KeyID: "k1", Encrypter: enc}}), "duplicate")
+	require.ErrorContains(t, r.Set([]Recipient{{Encrypter: enc}}), "without id")
+	require.Equal(t, []string{"k1"}, r.KeyIDs(), "a failed Set keeps the current recipients")
+
+	require.NoError(t, r.Set([]Recipient{{KeyID: "k1", Encrypter: enc}, {KeyID: "k2", Encrypter: enc}}))
+	require.Equal(t, []string{"k1", "k2"}, r.KeyIDs())
+	require.False(t, enc.destroyed, "an encrypter kept by Set is not destroyed")
+
+	next := NewX25519Encrypter(priv.PublicKey(), "", time.Minute)
+	require.NoError(t, r.Set([]Recipient{{KeyID: "k3", Encrypter: next}}))
+	require.True(t, enc.destroyed, "a replaced encrypter is destroyed")
+
+	r.Destroy()
+	require.True(t, next.destroyed)
+}
@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"context"
+	"log"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"murmapp.hook/internal/envelope"
+	"murmapp.hook/internal/metrics"
+)
+
+// keyExpiryWarning is how long before the payload key expires the hook
+// starts warning, and keyExpiryCheck how often it looks.
+const (
+	keyExpiryWarning = 7 * 24 * time.Hour
+	keyExpiryCheck   = time.Hour
+)
+
+var (
+	payloadKeyring atomic.Pointer[envelope.Keyring]
+
+	_ = metrics.NewGaugeFunc(
+		"hook_payload_key_expiry_seconds",
+		"Seconds until the key sealing payloads leaves its validity window; negative once it has, +Inf when it does not expire.",
+		func() float64 {
+			ring := payloadKeyring.Load()
+			if ring == nil {
+				return math.Inf(1)
+			}
+			return expiresIn(ring, time.Now()).Seconds()
+		},
+	)
+)
+
+func expiresIn(ring *envelope.Keyring, now time.Time) time.Duration {
+	exp := ring.ExpiresAt()
+	if exp.IsZero() {
+		return time.Duration(math.MaxInt64)
+	}
+	return exp.Sub(now)
+}
+
+// watchKeyExpiry exposes the payload key's expiry as a metric and logs a
+// warning while it is less than keyExpiryWarning away, so a new active key
+// can be set before the keyring falls back.
+func watchKeyExpiry(ctx context.Context, ring *envelope.Keyring) {
+	payloadKeyring.Store(ring)
+	ticker := time.NewTicker(keyExpiryCheck)
+	defer ticker.Stop()
+	for {
+		key, _ := ring.Active()
+		switch left := expiresIn(ring, time.Now()); {
+		case left <= 0:
+			log.Printf("❌ payload key %s expired at %s: set a new active key in the keyring",
+				key.ID, ring.ExpiresAt().Format(time.RFC3339))
+		case left < keyExpiryWarning:
+			log.Printf("⚠️ payload key %s expires at %s (in %s): set a new active key in the keyring",
+				key.ID, ring.ExpiresAt().Format(time.RFC3339), left.Truncate(time.Minute))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Package masterkey fetches the master key at startup, so it does not have
+// to be compiled into the binary. Every other secret is encrypted under keys
+// derived from it.
+package masterkey
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Provider returns the master key. Providers are asked once at startup.
+type Provider interface {
+	// Name describes where the key comes from, for logs; it never contains
+	// the key.
+	Name() string
+	MasterKey(ctx context.Context) ([]byte, error)
+}
+
+// ErrEmpty is returned when a source yields an empty key.
+var ErrEmpty = errors.New("master key is empty")
+
+// maxKeySize bounds what is read from a file, stdin or HTTP response.
+const maxKeySize = 4096
+
+// File reads the key from a file, e.g. a mounted Kubernetes or Docker
+// secret. A trailing newline is ignored.
+type File struct {
+	Path string
+}
+
+func (p File) Name() string { return "file " + p.Path }
+
+func (p File) MasterKey(context.Context) ([]byte, error) {
+	f, err := os.Open(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("master key file: %w", err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxKeySize))
+	if err != nil {
+		return nil, fmt.Errorf("master key file: %w", err)
+	}
+	return nonEmpty(trimNewline(data))
+}
+
+// Env reads the key from an environment variable.
+type Env struct {
+	Var string
+}
+
+func (p Env) Name() string { return "environment variable " + p.Var }
+
+func (p Env) MasterKey(context.Context) ([]byte, error) {
+	return nonEmpty([]byte(os.Getenv(p.Var)))
+}
+
+// Stdin reads the first line of r, so the key can be piped in at boot
+// without touching disk or the environment.
+type Stdin struct {
+	Reader io.Reader
+}
+
+func (p Stdin) Name() string { return "stdin" }
+
+func (p Stdin) MasterKey(context.Context) ([]byte, error) {
+	line, err := bufio.NewReader(io.LimitReader(p.Reader, maxKeySize)).ReadBytes('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("master key from stdin: %w", err)
+	}
+	return nonEmpty(trimNewline(line))
+}
+
+// HTTP fetches the key from a KMS-style endpoint: GET URL, optionally with a
+// bearer token, answering {"key": "..."}. A static file served over HTTP is
+// enough to stub it locally.
+type HTTP struct {
+	URL   string
+	Token string
+	// Client defaults to a client with a 10s timeout.
+	Client *http.Client
+}
+
+func (p HTTP) Name() string { return "HTTP endpoint " + p.URL }
+
+func (p HTTP) MasterKey(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("master key endpoint: %w", err)
+	}
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("master key endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("master key endpoint answered %s", resp.Status)
+	}
+
+	var body struct {
+		Key string `json:"key"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxKeySize)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("master key endpoint: decode response: %w", err)
+	}
+	return nonEmpty([]byte(body.Key))
+}
+
+// Static is a key known up front, such as one injected with -ldflags.
+type Static struct {
+	Source string
+	Key    string
+}
+
+func (p Static) Name() string { return p.Source }
+
+func (p Static) MasterKey(context.Context) ([]byte, error) {
+	return nonEmpty([]byte(p.Key))
+}
+
+func trimNewline(b []byte) []byte {
+	return []byte(strings.TrimRight(string(b), "\r\n"))
+}
+
+func nonEmpty(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrEmpty
+	}
+	return key, nil
+}
@@ -0,0 +1,77 @@
+package masterkey
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "master.key")
+	require.NoError(t, os.WriteFile(path, []byte("s3cret-master\n"), 0o600))
+
+	key, err := File{Path: path}.MasterKey(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "s3cret-master", string(key), "the trailing newline is dropped")
+
+	require.NoError(t, os.WriteFile(path, []byte("\n"), 0o600))
+	_, err = File{Path: path}.MasterKey(context.Background())
+	require.ErrorIs(t, err, ErrEmpty)
+
+	_, err = File{Path: filepath.Join(t.TempDir(), "missing")}.MasterKey(context.Background())
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestEnv(t *testing.T) {
+	t.Setenv("TEST_MASTER_KEY", "from-env")
+	key, err := Env{Var: "TEST_MASTER_KEY"}.MasterKey(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "from-env", string(key))
+
+	_, err = Env{Var: "TEST_MASTER_KEY_UNSET"}.MasterKey(context.Background())
+	require.ErrorIs(t, err, ErrEmpty)
+}
+
+func TestStdin(t *testing.T) {
+	key, err := Stdin{Reader: strings.NewReader("piped-key\r\nrest of input")}.MasterKey(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "piped-key", string(key), "only the first line is the key")
+
+	key, err = Stdin{Reader: strings.NewReader("no-newline")}.MasterKey(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "no-newline", string(key))
+
+	_, err = Stdin{Reader: strings.NewReader("")}.MasterKey(context.Background())
+	require.ErrorIs(t, err, ErrEmpty)
+}
+
+func TestHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer kms-token" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"key": "from-kms"}`))
+	}))
+	defer srv.Close()
+
+	p := HTTP{URL: srv.URL, Token: "kms-token"}
+	require.NotContains(t, p.Name(), "kms-token")
+	key, err := p.MasterKey(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "from-kms", string(key))
+
+	_, err = HTTP{URL: srv.URL}.MasterKey(context.Background())
+	require.ErrorContains(t, err, "403")
+}
+
+func TestStatic(t *testing.T) {
+	_, err := Static{Source: "-ldflags"}.MasterKey(context.Background())
+	require.ErrorIs(t, err, ErrEmpty)
+}
@@ -0,0 +1,160 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// collector writes its samples in the Prometheus text exposition format.
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	mu         sync.Mutex
+	collectors = map[string]collector{}
+)
+
+func register(c collector) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, exists := collectors[c.name()]; exists {
+		panic("metrics: duplicate registration of " + c.name())
+	}
+	collectors[c.name()] = c
+}
+
+// Handler serves all registered metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteTo(w)
+	})
+}
+
+// WriteTo writes all registered metrics sorted by name.
+func WriteTo(w io.Writer) {
+	mu.Lock()
+	names := make([]string, 0, len(collectors))
+	for n := range collectors {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	cs := make([]collector, 0, len(names))
+	for _, n := range names {
+		cs = append(cs, collectors[n])
+	}
+	mu.Unlock()
+
+	for _, c := range cs {
+		c.write(w)
+	}
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	n, help string
+	v       atomic.Uint64
+}
+
+// NewCounter creates and registers a counter.
+func NewCounter(name, help string) *Counter {
+	c := &Counter{n: name, help: help}
+	register(c)
+	return c
+}
+
+func (c *Counter) Inc()          { c.v.Add(1) }
+func (c *Counter) Add(n uint64)  { c.v.Add(n) }
+func (c *Counter) Value() uint64 { return c.v.Load() }
+func (c *Counter) name() string  { return c.n }
+func (c *Counter) write(w io.Writer) {
+	header(w, c.n, c.help, "counter")
+	fmt.Fprintf(w, "%s %d\n", c.n, c.v.Load())
+}
+
+// CounterVec is a family of counters partitioned by one label.
+type CounterVec struct {
+	n, help, label string
+	mu             sync.Mutex
+	values         map[string]*atomic.Uint64
+}
+
+// NewCounterVec creates and registers a counter family keyed by label.
+func NewCounterVec(name, help, label string) *CounterVec {
+	c := &CounterVec{n: name, help: help, label: label, values: map[string]*atomic.Uint64{}}
+	register(c)
+	return c
+}
+
+// Inc increments the counter for the given label value.
+func (c *CounterVec) Inc(value string) {
+	c.mu.Lock()
+	v, ok := c.values[value]
+	if !ok {
+		v = &atomic.Uint64{}
+		c.values[value] = v
+	}
+	c.mu.Unlock()
+	v.Add(1)
+}
+
+// Value returns the current count for the given label value.
+func (c *CounterVec) Value(value string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v, ok := c.values[value]; ok {
+		return v.Load()
+	}
+	return 0
+}
+
+func (c *CounterVec) name() string { return c.n }
+func (c *CounterVec) write(w io.Writer) {
+	header(w, c.n, c.help, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", c.n, c.label, escape(k), c.values[k].Load())
+	}
+}
+
+// GaugeFunc reports a value computed at scrape time.
+type GaugeFunc struct {
+	n, help string
+	fn      func() float64
+}
+
+// NewGaugeFunc creates and registers a gauge backed by fn.
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{n: name, help: help, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) name() string { return g.n }
+func (g *GaugeFunc) write(w io.Writer) {
+	header(w, g.n, g.help, "gauge")
+	fmt.Fprintf(w, "%s %g\n", g.n, g.fn())
+}
+
+func header(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,34 @@
+package metrics_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"murmapp.hook/internal/metrics"
+)
+
+func TestHandler(t *testing.T) {
+	c := metrics.NewCounter("test_events_total", "Events seen in tests.")
+	c.Inc()
+	c.Add(2)
+
+	v := metrics.NewCounterVec("test_events_by_bot_total", "Events by bot.", "webhook_id")
+	v.Inc("b")
+	v.Inc("a")
+	v.Inc("a")
+
+	metrics.NewGaugeFunc("test_queue_depth", "Queue depth.", func() float64 { return 7 })
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	require.Contains(t, body, "# TYPE test_events_total counter\ntest_events_total 3\n")
+	require.Contains(t, body, "test_events_by_bot_total{webhook_id=\"a\"} 2\n")
+	require.Contains(t, body, "test_events_by_bot_total{webhook_id=\"b\"} 1\n")
+	require.Contains(t, body, "# TYPE test_queue_depth gauge\ntest_queue_depth 7\n")
+	require.Less(t, strings.Index(body, "test_events_by_bot_total"), strings.Index(body, "test_queue_depth"))
+	require.Equal(t, uint64(2), v.Value("a"))
+}
@@ -0,0 +1,77 @@
+package rabbitmqinit
+
+import (
+	"fmt"
+
+	"github.com/streadway/amqp"
+	"murmapp.hook/internal/config"
+)
+
+// Channel is the subset of *amqp.Channel needed to declare the topology.
+type Channel interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+}
+
+// DeclareTopology declares the main exchange, the dead-letter exchange and
+// every configured queue with its dead-letter queue and bindings.
+// All declarations are idempotent as long as the arguments do not change;
+// RabbitMQ rejects a redeclaration with different arguments (PRECONDITION_FAILED).
+func DeclareTopology(ch Channel, t config.TopologyConfig) error {
+	if ch == nil {
+		return fmt.Errorf("DeclareTopology: channel is nil")
+	}
+
+	for _, name := range []string{t.Exchange, t.DeadLetterExchange} {
+		if err := ch.ExchangeDeclare(
+			name,
+			"topic",
+			true,  // durable
+			false, // auto-deleted
+			false, // internal
+			false, // no-wait
+			nil,
+		); err != nil {
+			return fmt.Errorf("declare exchange %s: %w", name, err)
+		}
+	}
+
+	for _, q := range t.Queues {
+		dlq := q.Name + ".dlq"
+		if _, err := ch.QueueDeclare(dlq, true, false, false, false, queueArgs(t, false)); err != nil {
+			return fmt.Errorf("declare queue %s: %w", dlq, err)
+		}
+		if err := ch.QueueBind(dlq, q.RoutingKey, t.DeadLetterExchange, false, nil); err != nil {
+			return fmt.Errorf("bind queue %s: %w", dlq, err)
+		}
+
+		if _, err := ch.QueueDeclare(q.Name, true, false, false, false, queueArgs(t, true)); err != nil {
+			return fmt.Errorf("declare queue %s: %w", q.Name, err)
+		}
+		if err := ch.QueueBind(q.Name, q.RoutingKey, t.Exchange, false, nil); err != nil {
+			return fmt.Errorf("bind queue %s: %w", q.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// queueArgs builds the x-arguments for a queue. Limits and dead-lettering
+// only apply to primary queues; dead-letter queues keep everything they receive.
+func queueArgs(t config.TopologyConfig, primary bool) amqp.Table {
+	args := amqp.Table{"x-queue-type": t.QueueType}
+	if !primary {
+		return args
+	}
+
+	args["x-dead-letter-exchange"] = t.DeadLetterExchange
+	if t.MessageTTL > 0 {
+		args["x-message-ttl"] = t.MessageTTL.Milliseconds()
+	}
+	if t.MaxLength > 0 {
+		args["x-max-length"] = int64(t.MaxLength)
+		args["x-overflow"] = t.Overflow
+	}
+	return args
+}
@@ -0,0 +1,79 @@
+package rabbitmqinit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/require"
+	"murmapp.hook/internal/config"
+	"murmapp.hook/internal/rabbitmqinit"
+)
+
+type declaredQueue struct {
+	name string
+	args amqp.Table
+}
+
+type binding struct {
+	queue, key, exchange string
+}
+
+type recordingChannel struct {
+	exchanges []string
+	queues    []declaredQueue
+	bindings  []binding
+}
+
+func (c *recordingChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	c.exchanges = append(c.exchanges, name)
+	return nil
+}
+
+func (c *recordingChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	c.queues = append(c.queues, declaredQueue{name: name, args: args})
+	return amqp.Queue{Name: name}, nil
+}
+
+func (c *recordingChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	c.bindings = append(c.bindings, binding{queue: name, key: key, exchange: exchange})
+	return nil
+}
+
+func TestDeclareTopology(t *testing.T) {
+	topology := config.TopologyConfig{
+		Exchange:           "murmapp",
+		DeadLetterExchange: "murmapp.dlx",
+		QueueType:          "quorum",
+		MessageTTL:         time.Hour,
+		MaxLength:          1000,
+		Overflow:           "reject-publish",
+		Queues: []config.QueueConfig{
+			{Name: "telegram.messages.in", RoutingKey: "telegram.messages.in"},
+		},
+	}
+
+	ch := &recordingChannel{}
+	require.NoError(t, rabbitmqinit.DeclareTopology(ch, topology))
+
+	require.Equal(t, []string{"murmapp", "murmapp.dlx"}, ch.exchanges)
+	require.Len(t, ch.queues, 2)
+
+	dlq := ch.queues[0]
+	require.Equal(t, "telegram.messages.in.dlq", dlq.name)
+	require.Equal(t, amqp.Table{"x-queue-type": "quorum"}, dlq.args)
+
+	primary := ch.queues[1]
+	require.Equal(t, "telegram.messages.in", primary.name)
+	require.Equal(t, "murmapp.dlx", primary.args["x-dead-letter-exchange"])
+	require.Equal(t, int64(3600000), primary.args["x-message-ttl"])
+	require.Equal(t, int64(1000), primary.args["x-max-length"])
+	require.Equal(t, "reject-publish", primary.args["x-overflow"])
+
+	require.Contains(t, ch.bindings, binding{"telegram.messages.in", "telegram.messages.in", "murmapp"})
+	require.Contains(t, ch.bindings, binding{"telegram.messages.in.dlq", "telegram.messages.in", "murmapp.dlx"})
+}
+
+func TestDeclareTopology_nilChannel(t *testing.T) {
+	require.Error(t, rabbitmqinit.DeclareTopology(nil, config.TopologyConfig{}))
+}
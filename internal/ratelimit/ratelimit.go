@@ -0,0 +1,164 @@
+// Package ratelimit implements token-bucket limits applied globally and per
+// webhook, with a reserve that only high-priority updates may use.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"murmapp.hook/internal/cache"
+)
+
+// maxTrackedWebhooks bounds the number of per-webhook buckets kept in memory.
+const maxTrackedWebhooks = 10000
+
+// bucketIdleTTL is how long an unused per-webhook bucket is kept. A bucket
+// that was forgotten is recreated full, which only matters for rates so low
+// that refilling takes longer than this.
+const bucketIdleTTL = 10 * time.Minute
+
+// lowPriorityReserve is the share of a bucket that low-priority updates may
+// not consume, so they are shed before regular traffic is limited.
+const lowPriorityReserve = 0.5
+
+// Limit is a sustained rate in requests per second and the burst allowed on
+// top of it. A zero Rate means unlimited.
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Decision is the outcome of Limiter.Allow.
+type Decision int
+
+const (
+	Allowed Decision = iota
+	// Shed means a low-priority update hit the reserve; regular updates
+	// would still have been accepted.
+	Shed
+	// Limited means the bucket is empty for every priority.
+	Limited
+)
+
+// Limiter applies a global limit and an independent limit per webhook.
+type Limiter struct {
+	global     *bucket
+	perWebhook Limit
+	webhooks   *cache.LRU[string, *bucket]
+	now        func() time.Time
+}
+
+func NewLimiter(global, perWebhook Limit) *Limiter {
+	l := &Limiter{
+		perWebhook: perWebhook,
+		webhooks:   cache.NewLRU[string, *bucket](maxTrackedWebhooks, bucketIdleTTL),
+		now:        time.Now,
+	}
+	if global.Rate > 0 {
+		l.global = newBucket(global)
+	}
+	return l
+}
+
+// Allow takes a token for webhookID from the per-webhook and global buckets.
+// When the update is refused it returns how long to wait before retrying.
+func (l *Limiter) Allow(webhookID string, lowPriority bool) (Decision, time.Duration) {
+	return l.AllowLimit(webhookID, l.perWebhook, lowPriority)
+}
+
+// AllowLimit is Allow with limit in place of the default per-webhook limit,
+// for bots with their own rate. A zero limit.Rate leaves the bot unlimited
+// apart from the global bucket.
+func (l *Limiter) AllowLimit(webhookID string, limit Limit, lowPriority bool) (Decision, time.Duration) {
+	now := l.now()
+	reserve := 0.0
+	if lowPriority {
+		reserve = lowPriorityReserve
+	}
+
+	var wb *bucket
+	if limit.Rate > 0 {
+		var ok bool
+		if wb, ok = l.webhooks.Get(webhookID); !ok {
+			wb, _ = l.webhooks.GetOrAdd(webhookID, newBucket(limit))
+		}
+		wb.setLimit(limit)
+		if d, wait := wb.take(now, reserve); d != Allowed {
+			return d, wait
+		}
+	}
+
+	if l.global != nil {
+		if d, wait := l.global.take(now, reserve); d != Allowed {
+			if wb != nil {
+				wb.refund()
+			}
+			return d, wait
+		}
+	}
+	return Allowed, 0
+}
+
+type bucket struct {
+	mu     sync.Mutex
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+// newBucket returns a full bucket; refilling starts with the first take.
+func newBucket(limit Limit) *bucket {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &bucket{limit: limit, tokens: float64(limit.Burst)}
+}
+
+// take consumes one token unless that would leave less than reserve (a share
+// of the burst) in the bucket. A burst too small to hold a reserve has none,
+// so low-priority updates are never shed forever.
+func (b *bucket) take(now time.Time, reserve float64) (Decision, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	burst := float64(b.limit.Burst)
+	if b.last.IsZero() {
+		b.last = now
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed.Seconds()*b.limit.Rate)
+		b.last = now
+	}
+
+	need := math.Min(1+reserve*burst, burst)
+	if b.tokens >= need {
+		b.tokens--
+		return Allowed, 0
+	}
+
+	wait := time.Duration((need - b.tokens) / b.limit.Rate * float64(time.Second))
+	if reserve > 0 && b.tokens >= 1 {
+		return Shed, wait
+	}
+	return Limited, wait
+}
+
+// setLimit applies a changed per-bot limit to an existing bucket.
+func (b *bucket) setLimit(limit Limit) {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limit != limit {
+		b.limit = limit
+		b.tokens = math.Min(b.tokens, float64(limit.Burst))
+	}
+}
+
+func (b *bucket) refund() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+1)
+}
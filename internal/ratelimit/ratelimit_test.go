@@ -0,0 +1,111 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter_perWebhook(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewLimiter(Limit{}, Limit{Rate: 1, Burst: 2})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		d, _ := l.Allow("a", false)
+		require.Equal(t, Allowed, d)
+	}
+	d, wait := l.Allow("a", false)
+	require.Equal(t, Limited, d)
+	require.Equal(t, time.Second, wait)
+
+	d, _ = l.Allow("b", false)
+	require.Equal(t, Allowed, d, "webhooks have independent buckets")
+
+	now = now.Add(time.Second)
+	d, _ = l.Allow("a", false)
+	require.Equal(t, Allowed, d)
+}
+
+func TestLimiter_global(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewLimiter(Limit{Rate: 1, Burst: 2}, Limit{Rate: 1, Burst: 2})
+	l.now = func() time.Time { return now }
+
+	d, _ := l.Allow("a", false)
+	require.Equal(t, Allowed, d)
+	d, _ = l.Allow("b", false)
+	require.Equal(t, Allowed, d)
+	d, _ = l.Allow("c", false)
+	require.Equal(t, Limited, d)
+
+	// The rejected request must not have used up c's own bucket.
+	now = now.Add(2 * time.Second)
+	for i := 0; i < 2; i++ {
+		d, _ = l.Allow("c", false)
+		require.Equal(t, Allowed, d)
+	}
+}
+
+func TestLimiter_shedsLowPriorityFirst(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewLimiter(Limit{}, Limit{Rate: 1, Burst: 4})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		d, _ := l.Allow("a", true)
+		require.Equal(t, Allowed, d)
+	}
+	d, _ := l.Allow("a", true)
+	require.Equal(t, Shed, d, "low-priority updates may not dip into the reserve")
+
+	for i := 0; i < 2; i++ {
+		d, _ = l.Allow("a", false)
+		require.Equal(t, Allowed, d)
+	}
+	d, _ = l.Allow("a", false)
+	require.Equal(t, Limited, d)
+}
+
+func TestLimiter_smallBurstHasNoReserve(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewLimiter(Limit{}, Limit{Rate: 1, Burst: 1})
+	l.now = func() time.Time { return now }
+
+	d, _ := l.Allow("a", true)
+	require.Equal(t, Allowed, d, "a burst of 1 cannot hold a reserve")
+	d, wait := l.Allow("a", true)
+	require.Equal(t, Limited, d)
+	require.Equal(t, time.Second, wait)
+
+	now = now.Add(time.Second)
+	d, _ = l.Allow("a", true)
+	require.Equal(t, Allowed, d, "low-priority updates pass again once the bucket refills")
+}
+
+func TestLimiter_unlimited(t *testing.T) {
+	l := NewLimiter(Limit{}, Limit{})
+	for i := 0; i < 100; i++ {
+		d, _ := l.Allow("a", true)
+		require.Equal(t, Allowed, d)
+	}
+}
+
+func TestLimiter_allowLimit(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewLimiter(Limit{}, Limit{Rate: 1, Burst: 1})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		d, _ := l.AllowLimit("vip", Limit{Rate: 1, Burst: 3}, false)
+		require.Equal(t, Allowed, d)
+	}
+	d, _ := l.AllowLimit("vip", Limit{Rate: 1, Burst: 3}, false)
+	require.Equal(t, Limited, d)
+
+	for i := 0; i < 100; i++ {
+		d, _ = l.AllowLimit("unlimited", Limit{}, false)
+		require.Equal(t, Allowed, d)
+	}
+}
@@ -0,0 +1,145 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	registrationsBucket = []byte("registrations")
+	botsBucket          = []byte("bots")
+	// previousBucket maps rotated-out webhook IDs to the current ones.
+	previousBucket = []byte("previous")
+)
+
+// BoltStore keeps registrations in a single bbolt file. The file is locked
+// by one process at a time, so replicas need their own files or a shared
+// store.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens or creates the database at path.
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open registration store %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{registrationsBucket, botsBucket, previousBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("init registration store: %w", err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Get(ctx context.Context, webhookID string) (Registration, error) {
+	var reg Registration
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return get(tx, webhookID, &reg)
+	})
+	return reg, err
+}
+
+func (s *BoltStore) GetByBot(ctx context.Context, botID string) (Registration, error) {
+	var reg Registration
+	err := s.db.View(func(tx *bolt.Tx) error {
+		webhookID := tx.Bucket(botsBucket).Get([]byte(botID))
+		if webhookID == nil {
+			return ErrNotFound
+		}
+		return get(tx, string(webhookID), &reg)
+	})
+	return reg, err
+}
+
+func (s *BoltStore) Put(ctx context.Context, reg Registration) error {
+	data, err := json.Marshal(reg)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if old := tx.Bucket(botsBucket).Get([]byte(reg.BotID)); old != nil {
+			var prev Registration
+			if err := get(tx, string(old), &prev); err != nil {
+				return err
+			}
+			if err := remove(tx, prev); err != nil {
+				return err
+			}
+		}
+		if err := tx.Bucket(registrationsBucket).Put([]byte(reg.WebhookID), data); err != nil {
+			return err
+		}
+		if reg.Previous != nil {
+			if err := tx.Bucket(previousBucket).Put([]byte(reg.Previous.WebhookID), []byte(reg.WebhookID)); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(botsBucket).Put([]byte(reg.BotID), []byte(reg.WebhookID))
+	})
+}
+
+func (s *BoltStore) Delete(ctx context.Context, webhookID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var reg Registration
+		if err := get(tx, webhookID, &reg); err != nil {
+			return err
+		}
+		return remove(tx, reg)
+	})
+}
+
+func (s *BoltStore) List(ctx context.Context) ([]Registration, error) {
+	var out []Registration
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(registrationsBucket).ForEach(func(k, v []byte) error {
+			var reg Registration
+			if err := json.Unmarshal(v, &reg); err != nil {
+				return fmt.Errorf("decode registration %s: %w", k, err)
+			}
+			out = append(out, reg)
+			return nil
+		})
+	})
+	return out, err
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func get(tx *bolt.Tx, webhookID string, reg *Registration) error {
+	key := []byte(webhookID)
+	if current := tx.Bucket(previousBucket).Get(key); current != nil {
+		key = current
+	}
+	data := tx.Bucket(registrationsBucket).Get(key)
+	if data == nil {
+		return ErrNotFound
+	}
+	return json.Unmarshal(data, reg)
+}
+
+func remove(tx *bolt.Tx, reg Registration) error {
+	if err := tx.Bucket(registrationsBucket).Delete([]byte(reg.WebhookID)); err != nil {
+		return err
+	}
+	if reg.Previous != nil {
+		if err := tx.Bucket(previousBucket).Delete([]byte(reg.Previous.WebhookID)); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(botsBucket).Delete([]byte(reg.BotID))
+}
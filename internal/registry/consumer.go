@@ -0,0 +1,115 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/eugene-ruby/xencryptor/xsecrets"
+	"google.golang.org/protobuf/proto"
+	"murmapp.hook/internal/broker"
+	"murmapp.hook/internal/telegram"
+	"murmapp.hook/pkg/hooksig"
+	hookpb "murmapp.hook/proto"
+)
+
+// Consumer answers RegisterWebhookRequest messages with a
+// RegisterWebhookResponse published on ReplyTopic.
+type Consumer struct {
+	Registry  *Registry
+	Publisher broker.Publisher
+	// APIKeyKey decrypts api_key_bot (AES-GCM via xsecrets).
+	APIKeyKey  []byte
+	ReplyTopic string
+	// Installer calls setWebhook for every registration. Nil skips it: a
+	// known bot then keeps its token, and a new bot's token is lost, as
+	// replies carry only the webhook ID, so that is only for tests.
+	Installer *Installer
+	// Signer signs replies like the hook's other messages; nil leaves them
+	// unsigned.
+	Signer *hooksig.Signer
+	// Exchange is the one Publisher publishes to, covered by signatures.
+	Exchange string
+}
+
+// Handle processes one delivery. Malformed requests return an error so the
+// broker dead-letters them instead of redelivering forever; Bot API failures
+// that may pass are returned as a *broker.RetryError so the request is
+// retried.
+func (c *Consumer) Handle(ctx context.Context, d broker.Delivery) error {
+	var req hookpb.RegisterWebhookRequest
+	if err := proto.Unmarshal(d.Body, &req); err != nil {
+		log.Printf("[registry] ❌ malformed RegisterWebhookRequest: %v", err)
+		return err
+	}
+
+	// The key is stored encrypted as received; decrypting it here rejects
+	// requests the hook could never use to call the Bot API.
+	if _, err := xsecrets.DecryptBytesWithKey(req.ApiKeyBot, c.APIKeyKey); err != nil {
+		log.Printf("[registry] ❌ failed to decrypt api_key_bot for bot_id=%s: %v", req.BotId, err)
+		return fmt.Errorf("decrypt api_key_bot: %w", err)
+	}
+
+	// The new token is committed only once Telegram has accepted it.
+	var install func(context.Context, Registration, string) error
+	if c.Installer != nil {
+		install = func(ctx context.Context, reg Registration, token string) error {
+			if err := c.Installer.Install(ctx, reg, token); err != nil {
+				log.Printf("[registry] ❌ setWebhook failed for bot_id=%s: %v", reg.BotID, err)
+				return retryable(err)
+			}
+			log.Printf("[registry] 🔗 webhook installed for bot_id=%s", reg.BotID)
+			return nil
+		}
+	}
+	reg, _, err := c.Registry.Register(ctx, req.BotId, req.ApiKeyBot, install)
+	if err != nil {
+		log.Printf("[registry] ❌ rejected registration: %v", err)
+		return err
+	}
+
+	resp := &hookpb.RegisterWebhookResponse{BotId: reg.BotID, WebhookId: reg.WebhookID}
+	data, err := proto.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{
+		broker.HeaderContentType: "application/x-protobuf",
+		broker.HeaderType:        string(proto.MessageName(resp)),
+		broker.HeaderMessageID:   reg.WebhookID,
+	}
+	if id := d.Headers[broker.HeaderMessageID]; id != "" {
+		headers["x-correlation-id"] = id
+	}
+	if c.Signer != nil {
+		c.Signer.Sign(headers, c.Exchange, c.ReplyTopic, data)
+	}
+	if err := c.Publisher.Publish(ctx, c.ReplyTopic, headers, data); err != nil {
+		log.Printf("[registry] ❌ failed to publish RegisterWebhookResponse: %v", err)
+		return err
+	}
+
+	log.Printf("[registry] ✅ registered bot_id=%s", reg.BotID)
+	return nil
+}
+
+// retryable marks err for a retry when the Bot API may accept the call
+// later: network errors, 429 (after the delay Telegram asks for) and 5xx.
+func retryable(err error) error {
+	var apiErr *telegram.APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= http.StatusInternalServerError {
+			return broker.Retry(err, apiErr.RetryAfter)
+		}
+		return err
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return broker.Retry(err, 0)
+	}
+	return err
+}
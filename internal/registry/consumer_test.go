@@ -0,0 +1,98 @@
+package registry
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eugene-ruby/xencryptor/xsecrets"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"murmapp.hook/internal/broker"
+	"murmapp.hook/internal/telegram"
+	"murmapp.hook/pkg/hooksig"
+	hookpb "murmapp.hook/proto"
+)
+
+func TestConsumer_handle(t *testing.T) {
+	key := []byte("payload-32-byte-key-abc123456789")
+	encrypted, err := xsecrets.EncryptBytesWithKey([]byte("123:abc"), key)
+	require.NoError(t, err)
+
+	body, err := proto.Marshal(&hookpb.RegisterWebhookRequest{BotId: "bot-1", ApiKeyBot: encrypted})
+	require.NoError(t, err)
+
+	signPub, signPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pub := broker.NewMemoryPublisher()
+	c := &Consumer{Registry: New(NewMemoryStore(), "salt", 0, 0), Publisher: pub, APIKeyKey: key, ReplyTopic: "telegram.webhook.registered",
+		Signer: hooksig.NewSigner(signPriv), Exchange: "murmapp"}
+
+	err = c.Handle(context.Background(), broker.Delivery{
+		Headers: map[string]string{broker.HeaderMessageID: "req-1"},
+		Body:    body,
+	})
+	require.NoError(t, err)
+
+	msgs := pub.Messages()
+	require.Len(t, msgs, 1)
+	require.Equal(t, "telegram.webhook.registered", msgs[0].Topic)
+	require.Equal(t, "req-1", msgs[0].Headers["x-correlation-id"])
+	v := hooksig.NewVerifier(map[string]ed25519.PublicKey{hooksig.KeyID(signPub): signPub}, 0)
+	require.NoError(t, v.Verify(msgs[0].Headers, "murmapp", msgs[0].Topic, msgs[0].Body))
+
+	var resp hookpb.RegisterWebhookResponse
+	require.NoError(t, proto.Unmarshal(msgs[0].Body, &resp))
+	require.Equal(t, "bot-1", resp.BotId)
+
+	reg, err := c.Registry.Lookup(context.Background(), resp.WebhookId)
+	require.NoError(t, err)
+	require.Equal(t, encrypted, reg.EncryptedAPIKey)
+}
+
+func TestConsumer_rejectsUndecryptableKey(t *testing.T) {
+	body, err := proto.Marshal(&hookpb.RegisterWebhookRequest{BotId: "bot-1", ApiKeyBot: []byte("garbage")})
+	require.NoError(t, err)
+
+	pub := broker.NewMemoryPublisher()
+	c := &Consumer{Registry: New(NewMemoryStore(), "salt", 0, 0), Publisher: pub, APIKeyKey: []byte("payload-32-byte-key-abc123456789")}
+
+	require.Error(t, c.Handle(context.Background(), broker.Delivery{Body: body}))
+	require.Empty(t, pub.Messages())
+}
+
+func TestConsumer_retriesTransientBotAPIFailures(t *testing.T) {
+	code := http.StatusBadGateway
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"ok": false, "error_code": code, "description": "down"})
+	}))
+	defer srv.Close()
+
+	key := []byte("payload-32-byte-key-abc123456789")
+	encrypted, err := xsecrets.EncryptBytesWithKey([]byte("123:abc"), key)
+	require.NoError(t, err)
+	body, err := proto.Marshal(&hookpb.RegisterWebhookRequest{BotId: "bot-1", ApiKeyBot: encrypted})
+	require.NoError(t, err)
+
+	pub := broker.NewMemoryPublisher()
+	c := &Consumer{Registry: New(NewMemoryStore(), "salt", 0, 0), Publisher: pub, APIKeyKey: key, ReplyTopic: "telegram.webhook.registered",
+		Installer: &Installer{Client: telegram.NewClient(srv.URL, time.Second), APIKeyKey: key, BaseURL: "https://hook.example.com/api/webhook"}}
+
+	err = c.Handle(context.Background(), broker.Delivery{Body: body})
+	var retry *broker.RetryError
+	require.ErrorAs(t, err, &retry)
+	require.Empty(t, pub.Messages())
+	_, err = c.Registry.LookupBot(context.Background(), "bot-1")
+	require.ErrorIs(t, err, ErrNotFound, "nothing is stored until setWebhook succeeds")
+
+	code = http.StatusUnauthorized
+	err = c.Handle(context.Background(), broker.Delivery{Body: body})
+	require.Error(t, err)
+	require.False(t, errors.As(err, &retry), "a rejected bot token is dead-lettered")
+}
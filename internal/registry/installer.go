@@ -0,0 +1,78 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/eugene-ruby/xencryptor/xsecrets"
+	"murmapp.hook/internal/telegram"
+)
+
+// Installer points a registered bot's Telegram webhook at the hook.
+type Installer struct {
+	Client *telegram.Client
+	// APIKeyKey decrypts Registration.EncryptedAPIKey.
+	APIKeyKey []byte
+	// BaseURL is the public URL of the webhook route without the webhook ID,
+	// e.g. https://hook.example.com/api/webhook.
+	BaseURL        string
+	AllowedUpdates []string
+	MaxConnections int
+}
+
+// URL returns the webhook URL Telegram should call for reg.
+func (i *Installer) URL(reg Registration) string {
+	return strings.TrimRight(i.BaseURL, "/") + "/" + reg.WebhookID
+}
+
+// Install calls setWebhook with secretToken and confirms through
+// getWebhookInfo that Telegram now delivers to the hook.
+func (i *Installer) Install(ctx context.Context, reg Registration, secretToken string) error {
+	apiKey, err := i.apiKey(reg)
+	if err != nil {
+		return err
+	}
+
+	allowed := i.AllowedUpdates
+	if len(reg.Overrides.AllowedUpdates) > 0 {
+		allowed = reg.Overrides.AllowedUpdates
+	}
+
+	url := i.URL(reg)
+	err = i.Client.SetWebhook(ctx, apiKey, telegram.SetWebhookParams{
+		URL:            url,
+		SecretToken:    secretToken,
+		AllowedUpdates: allowed,
+		MaxConnections: i.MaxConnections,
+	})
+	if err != nil {
+		return err
+	}
+
+	info, err := i.Client.GetWebhookInfo(ctx, apiKey)
+	if err != nil {
+		return err
+	}
+	if info.URL != url {
+		return fmt.Errorf("webhook for bot_id=%s not applied: Telegram reports %q", reg.BotID, info.URL)
+	}
+	return nil
+}
+
+// Uninstall removes the bot's webhook; pending updates are left to Telegram.
+func (i *Installer) Uninstall(ctx context.Context, reg Registration) error {
+	apiKey, err := i.apiKey(reg)
+	if err != nil {
+		return err
+	}
+	return i.Client.DeleteWebhook(ctx, apiKey, false)
+}
+
+func (i *Installer) apiKey(reg Registration) (string, error) {
+	key, err := xsecrets.DecryptBytesWithKey(reg.EncryptedAPIKey, i.APIKeyKey)
+	if err != nil {
+		return "", fmt.Errorf("decrypt api key of bot_id=%s: %w", reg.BotID, err)
+	}
+	return string(key), nil
+}
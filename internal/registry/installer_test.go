@@ -0,0 +1,54 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eugene-ruby/xencryptor/xsecrets"
+	"github.com/stretchr/testify/require"
+	"murmapp.hook/internal/telegram"
+)
+
+func TestInstaller_install(t *testing.T) {
+	var set telegram.SetWebhookParams
+	reportedURL := ""
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bot123:abc/setWebhook":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&set))
+			w.Write([]byte(`{"ok":true,"result":true}`))
+		case "/bot123:abc/getWebhookInfo":
+			json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": map[string]any{"url": reportedURL}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	key := []byte("payload-32-byte-key-abc123456789")
+	apiKey, err := xsecrets.EncryptBytesWithKey([]byte("123:abc"), key)
+	require.NoError(t, err)
+
+	inst := &Installer{
+		Client:         telegram.NewClient(srv.URL, time.Second),
+		APIKeyKey:      key,
+		BaseURL:        "https://hook.example.com/api/webhook/",
+		AllowedUpdates: []string{"message"},
+		MaxConnections: 10,
+	}
+	reg := Registration{BotID: "bot-1", WebhookID: "wid", EncryptedAPIKey: apiKey}
+
+	reportedURL = "https://hook.example.com/api/webhook/wid"
+	require.NoError(t, inst.Install(context.Background(), reg, "tok"))
+	require.Equal(t, reportedURL, set.URL)
+	require.Equal(t, "tok", set.SecretToken)
+	require.Equal(t, 10, set.MaxConnections)
+
+	reportedURL = "https://elsewhere.example.com/"
+	require.ErrorContains(t, inst.Install(context.Background(), reg, "tok"), "not applied")
+}
@@ -0,0 +1,90 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"murmapp.hook/internal/ratelimit"
+)
+
+// Policies for updates in which no privacy rule matched.
+const (
+	// UnmatchedDrop discards the update; this is the default.
+	UnmatchedDrop = "drop"
+	// UnmatchedForward publishes the update unredacted (still encrypted).
+	UnmatchedForward = "forward"
+)
+
+// Overrides changes how one bot's updates are processed. Zero fields keep
+// the service-wide behaviour.
+type Overrides struct {
+	// ExtraRules and RemovedRules adjust the embedded privacy-key paths.
+	ExtraRules   []string `json:"extra_rules,omitempty"`
+	RemovedRules []string `json:"removed_rules,omitempty"`
+	// Exchange and RoutingKey redirect telegram.messages.in payloads;
+	// encrypted Telegram IDs always go to the default route.
+	Exchange   string `json:"exchange,omitempty"`
+	RoutingKey string `json:"routing_key,omitempty"`
+	// AllowedUpdates drops update types not listed; empty allows all.
+	AllowedUpdates []string         `json:"allowed_updates,omitempty"`
+	Unmatched      string           `json:"unmatched,omitempty"`
+	RateLimit      *ratelimit.Limit `json:"rate_limit,omitempty"`
+}
+
+// Merge returns o with every field set in top replacing its counterpart.
+func (o Overrides) Merge(top Overrides) Overrides {
+	if top.ExtraRules != nil {
+		o.ExtraRules = top.ExtraRules
+	}
+	if top.RemovedRules != nil {
+		o.RemovedRules = top.RemovedRules
+	}
+	if top.Exchange != "" {
+		o.Exchange = top.Exchange
+	}
+	if top.RoutingKey != "" {
+		o.RoutingKey = top.RoutingKey
+	}
+	if top.AllowedUpdates != nil {
+		o.AllowedUpdates = top.AllowedUpdates
+	}
+	if top.Unmatched != "" {
+		o.Unmatched = top.Unmatched
+	}
+	if top.RateLimit != nil {
+		o.RateLimit = top.RateLimit
+	}
+	return o
+}
+
+// Validate rejects values the handler cannot apply.
+func (o Overrides) Validate() error {
+	switch o.Unmatched {
+	case "", UnmatchedDrop, UnmatchedForward:
+	default:
+		return fmt.Errorf("unmatched must be %q or %q, got %q", UnmatchedDrop, UnmatchedForward, o.Unmatched)
+	}
+	if o.RateLimit != nil && (o.RateLimit.Rate < 0 || o.RateLimit.Burst < 0) {
+		return fmt.Errorf("rate_limit must not be negative")
+	}
+	return nil
+}
+
+// LoadOverrides reads a JSON object mapping webhook IDs to overrides.
+func LoadOverrides(path string) (map[string]Overrides, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read overrides: %w", err)
+	}
+	var out map[string]Overrides
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("parse overrides %s: %w", path, err)
+	}
+	for webhookID, o := range out {
+		if err := o.Validate(); err != nil {
+			return nil, fmt.Errorf("overrides for %s: %w", webhookID, err)
+		}
+	}
+	return out, nil
+}
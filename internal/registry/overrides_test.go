@@ -0,0 +1,37 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"murmapp.hook/internal/ratelimit"
+)
+
+func TestOverrides_merge(t *testing.T) {
+	base := Overrides{RoutingKey: "bot.in", Unmatched: UnmatchedForward, ExtraRules: []string{"a"}}
+	top := Overrides{RoutingKey: "ops.in", RateLimit: &ratelimit.Limit{Rate: 1, Burst: 1}}
+
+	got := base.Merge(top)
+	require.Equal(t, "ops.in", got.RoutingKey)
+	require.Equal(t, UnmatchedForward, got.Unmatched)
+	require.Equal(t, []string{"a"}, got.ExtraRules)
+	require.Equal(t, 1.0, got.RateLimit.Rate)
+}
+
+func TestLoadOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overrides.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"wid": {"routing_key": "bot.in", "unmatched": "forward", "rate_limit": {"rate": 2, "burst": 5}}
+	}`), 0o600))
+
+	got, err := LoadOverrides(path)
+	require.NoError(t, err)
+	require.Equal(t, "bot.in", got["wid"].RoutingKey)
+	require.Equal(t, ratelimit.Limit{Rate: 2, Burst: 5}, *got["wid"].RateLimit)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"wid": {"unmatched": "keep"}}`), 0o600))
+	_, err = LoadOverrides(path)
+	require.ErrorContains(t, err, "unmatched")
+}
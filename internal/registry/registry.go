@@ -0,0 +1,327 @@
+// Package registry keeps the bots registered with the hook and the secret
+// token each of them uses for its webhook.
+package registry
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"murmapp.hook/internal/cache"
+)
+
+// ErrEmptyBotID is returned when a registration names no bot.
+var ErrEmptyBotID = errors.New("bot_id must not be empty")
+
+// Registry looks up registrations for incoming webhooks through an
+// in-memory cache in front of a RegistrationStore.
+type Registry struct {
+	store RegistrationStore
+	salt  string
+	// cache holds recently used registrations by webhook ID; nil disables it.
+	cache *cache.LRU[string, Registration]
+	now   func() time.Time
+	// bots serialises Register, Rotate, Unregister and RetireExpired per bot,
+	// so one cannot restore a registration another has just replaced.
+	bots botLocks
+}
+
+// New creates a registry over store; salt is mixed into every token hash.
+// Lookups are cached for up to cacheTTL, a zero cacheSize disables caching.
+func New(store RegistrationStore, salt string, cacheSize int, cacheTTL time.Duration) *Registry {
+	r := &Registry{store: store, salt: salt, now: time.Now}
+	if cacheSize > 0 {
+		r.cache = cache.NewLRU[string, Registration](cacheSize, cacheTTL)
+	}
+	return r
+}
+
+// Register stores botID with encryptedAPIKey and returns the registration
+// together with the plaintext secret token, which is not kept. A new bot's
+// webhook ID is derived from its first token; a known bot keeps its webhook
+// ID, so redelivered requests do not move the webhook URL.
+//
+// apply, typically Installer.Install, hands the token to Telegram before
+// anything is stored: if it fails the stored registration is untouched and
+// the old token keeps working. Without apply nobody could tell Telegram a
+// new token, so a known bot keeps its stored token hash and the returned
+// token is empty.
+func (r *Registry) Register(ctx context.Context, botID string, encryptedAPIKey []byte, apply func(context.Context, Registration, string) error) (Registration, string, error) {
+	if botID == "" {
+		return Registration{}, "", ErrEmptyBotID
+	}
+	defer r.bots.lock(botID)()
+
+	now := r.now()
+	old, err := r.store.GetByBot(ctx, botID)
+	known := err == nil
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return Registration{}, "", err
+	}
+
+	reg := old
+	token := ""
+	if !known || apply != nil {
+		token = NewSecretToken()
+		reg.SecretTokenHash = WebhookID(token, r.salt)
+	}
+	if !known {
+		reg = Registration{
+			WebhookID:       reg.SecretTokenHash,
+			BotID:           botID,
+			SecretTokenHash: reg.SecretTokenHash,
+			Status:          StatusActive,
+			CreatedAt:       now,
+		}
+	}
+	reg.EncryptedAPIKey = encryptedAPIKey
+	reg.UpdatedAt = now
+
+	// Deliveries carrying the new token are refused until it is stored.
+	// Telegram retries those, and should the hook stop in between, the
+	// unacknowledged request is redelivered and registers the bot again.
+	if apply != nil {
+		if err := apply(ctx, reg, token); err != nil {
+			return Registration{}, "", err
+		}
+	}
+	if err := r.store.Put(ctx, reg); err != nil {
+		return Registration{}, "", err
+	}
+	r.evict(old)
+	r.cached(reg)
+	return reg, token, nil
+}
+
+// Rotate issues botID a new secret token and with it a new webhook ID. The
+// old webhook ID keeps being served for grace, then it is retired. apply,
+// typically Installer.Install, hands the new token to Telegram; if it fails
+// the rotation is undone. A rotation still in its grace period is cut short.
+func (r *Registry) Rotate(ctx context.Context, botID string, grace time.Duration, apply func(context.Context, Registration, string) error) (Registration, string, error) {
+	defer r.bots.lock(botID)()
+
+	old, err := r.store.GetByBot(ctx, botID)
+	if err != nil {
+		return Registration{}, "", err
+	}
+
+	token := NewSecretToken()
+	now := r.now()
+
+	reg := old
+	reg.WebhookID = WebhookID(token, r.salt)
+	reg.SecretTokenHash = reg.WebhookID
+	reg.Previous = &RetiredWebhook{
+		WebhookID:       old.WebhookID,
+		SecretTokenHash: old.SecretTokenHash,
+		ExpiresAt:       now.Add(grace),
+	}
+	reg.UpdatedAt = now
+
+	// The new ID is stored before Telegram learns about it, so the first
+	// delivery to the new URL is already accepted.
+	if err := r.store.Put(ctx, reg); err != nil {
+		return Registration{}, "", err
+	}
+	r.evict(old)
+
+	if apply != nil {
+		if err := apply(ctx, reg, token); err != nil {
+			if restoreErr := r.store.Put(ctx, old); restoreErr != nil {
+				return Registration{}, "", fmt.Errorf("%w (restoring registration: %v)", err, restoreErr)
+			}
+			r.evict(reg)
+			return Registration{}, "", err
+		}
+	}
+	return reg, token, nil
+}
+
+// RetireExpired forgets rotated-out webhook IDs whose grace period is over
+// and returns how many were retired.
+func (r *Registry) RetireExpired(ctx context.Context) (int, error) {
+	regs, err := r.store.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	now := r.now()
+	retired := 0
+	for _, reg := range regs {
+		if reg.Previous == nil || now.Before(reg.Previous.ExpiresAt) {
+			continue
+		}
+		ok, err := r.retire(ctx, reg.BotID, now)
+		if err != nil {
+			return retired, err
+		}
+		if ok {
+			retired++
+		}
+	}
+	return retired, nil
+}
+
+// retire drops botID's rotated-out webhook ID if its grace period is over.
+// The registration is read again under the bot's lock, as a rotation may
+// have replaced it since it was listed.
+func (r *Registry) retire(ctx context.Context, botID string, now time.Time) (bool, error) {
+	defer r.bots.lock(botID)()
+
+	reg, err := r.store.GetByBot(ctx, botID)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if reg.Previous == nil || now.Before(reg.Previous.ExpiresAt) {
+		return false, nil
+	}
+	old := reg
+	reg.Previous = nil
+	if err := r.store.Put(ctx, reg); err != nil {
+		return false, err
+	}
+	r.evict(old)
+	return true, nil
+}
+
+// Lookup returns the registration for webhookID, or ErrNotFound. A webhook
+// ID replaced by a rotation is found until its grace period ends.
+func (r *Registry) Lookup(ctx context.Context, webhookID string) (Registration, error) {
+	reg, ok := Registration{}, false
+	if r.cache != nil {
+		reg, ok = r.cache.Get(webhookID)
+	}
+	if !ok {
+		var err error
+		if reg, err = r.store.Get(ctx, webhookID); err != nil {
+			return Registration{}, err
+		}
+		if r.cache != nil {
+			r.cache.Add(webhookID, reg)
+		}
+	}
+	if reg.Previous != nil && webhookID == reg.Previous.WebhookID && !r.now().Before(reg.Previous.ExpiresAt) {
+		return Registration{}, ErrNotFound
+	}
+	return reg, nil
+}
+
+// LookupBot returns the registration of botID, or ErrNotFound.
+func (r *Registry) LookupBot(ctx context.Context, botID string) (Registration, error) {
+	return r.store.GetByBot(ctx, botID)
+}
+
+// List returns every stored registration.
+func (r *Registry) List(ctx context.Context) ([]Registration, error) {
+	return r.store.List(ctx)
+}
+
+// Unregister removes botID so its webhook ID is no longer accepted.
+func (r *Registry) Unregister(ctx context.Context, botID string) (Registration, error) {
+	defer r.bots.lock(botID)()
+
+	reg, err := r.store.GetByBot(ctx, botID)
+	if err != nil {
+		return Registration{}, err
+	}
+	if err := r.store.Delete(ctx, reg.WebhookID); err != nil {
+		return Registration{}, err
+	}
+	r.evict(reg)
+	return reg, nil
+}
+
+// VerifyToken reports in constant time whether token is accepted for
+// webhookID. The current token is accepted on both the current and the
+// rotated-out webhook ID, the previous token only on the latter.
+func (r *Registry) VerifyToken(reg Registration, webhookID, token string) bool {
+	got := []byte(WebhookID(token, r.salt))
+	ok := subtle.ConstantTimeCompare(got, []byte(reg.SecretTokenHash)) == 1
+	if reg.Previous != nil && webhookID == reg.Previous.WebhookID {
+		ok = subtle.ConstantTimeCompare(got, []byte(reg.Previous.SecretTokenHash)) == 1 || ok
+	}
+	return ok
+}
+
+// Close closes the underlying store.
+func (r *Registry) Close() error {
+	return r.store.Close()
+}
+
+func (r *Registry) cached(reg Registration) {
+	if r.cache != nil {
+		r.cache.Add(reg.WebhookID, reg)
+	}
+}
+
+// evict drops every cache entry reg may be held under.
+func (r *Registry) evict(reg Registration) {
+	if r.cache == nil {
+		return
+	}
+	r.cache.Remove(reg.WebhookID)
+	if reg.Previous != nil {
+		r.cache.Remove(reg.Previous.WebhookID)
+	}
+}
+
+// botLocks hands out one mutex per bot ID, dropped once nobody holds it.
+type botLocks struct {
+	mu    sync.Mutex
+	locks map[string]*botLock
+}
+
+type botLock struct {
+	sync.Mutex
+	users int
+}
+
+// lock locks botID and returns the matching unlock.
+func (b *botLocks) lock(botID string) func() {
+	b.mu.Lock()
+	if b.locks == nil {
+		b.locks = map[string]*botLock{}
+	}
+	l := b.locks[botID]
+	if l == nil {
+		l = &botLock{}
+		b.locks[botID] = l
+	}
+	l.users++
+	b.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		b.mu.Lock()
+		if l.users--; l.users == 0 {
+			delete(b.locks, botID)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// NewSecretToken returns 256 random bits in the alphabet Telegram accepts
+// for secret_token (A-Z, a-z, 0-9, _ and -).
+func NewSecretToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// WebhookID derives the public webhook path segment from the secret token,
+// so the token itself never appears in URLs or access logs.
+func WebhookID(secretToken, secretSalt string) string {
+	h := sha256.New()
+	h.Write([]byte(secretToken + secretSalt))
+	return hex.EncodeToString(h.Sum(nil))
+}
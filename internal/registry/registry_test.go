@@ -0,0 +1,210 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_register(t *testing.T) {
+	ctx := context.Background()
+	r := New(NewMemoryStore(), "salt", 10, time.Minute)
+
+	reg, token, err := r.Register(ctx, "bot-1", []byte("encrypted"), nil)
+	require.NoError(t, err)
+	require.Equal(t, WebhookID(token, "salt"), reg.WebhookID)
+	require.Regexp(t, regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`), token)
+	require.True(t, reg.Active())
+
+	got, err := r.Lookup(ctx, reg.WebhookID)
+	require.NoError(t, err)
+	require.Equal(t, "bot-1", got.BotID)
+	require.True(t, r.VerifyToken(got, reg.WebhookID, token))
+	require.False(t, r.VerifyToken(got, reg.WebhookID, "other"))
+
+	_, err = r.Lookup(ctx, "unknown")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRegistry_reregisterKeepsWebhookID(t *testing.T) {
+	ctx := context.Background()
+	r := New(NewMemoryStore(), "salt", 10, time.Minute)
+	installed := func(context.Context, Registration, string) error { return nil }
+
+	first, oldToken, err := r.Register(ctx, "bot-1", []byte("old"), installed)
+	require.NoError(t, err)
+	second, newToken, err := r.Register(ctx, "bot-1", []byte("new"), installed)
+	require.NoError(t, err)
+
+	require.Equal(t, first.WebhookID, second.WebhookID)
+	require.Equal(t, []byte("new"), second.EncryptedAPIKey)
+
+	got, err := r.Lookup(ctx, first.WebhookID)
+	require.NoError(t, err)
+	require.True(t, r.VerifyToken(got, first.WebhookID, newToken))
+	require.False(t, r.VerifyToken(got, first.WebhookID, oldToken))
+
+	// Without an installer Telegram keeps the old token, so the hash stays.
+	third, token, err := r.Register(ctx, "bot-1", []byte("newer"), nil)
+	require.NoError(t, err)
+	require.Empty(t, token)
+	require.Equal(t, second.SecretTokenHash, third.SecretTokenHash)
+	require.Equal(t, []byte("newer"), third.EncryptedAPIKey)
+
+	_, _, err = r.Register(ctx, "", nil, nil)
+	require.ErrorIs(t, err, ErrEmptyBotID)
+}
+
+func TestRegistry_registerUndoneWhenApplyFails(t *testing.T) {
+	ctx := context.Background()
+	r := New(NewMemoryStore(), "salt", 10, time.Minute)
+	failed := errors.New("setWebhook failed")
+	fail := func(context.Context, Registration, string) error { return failed }
+
+	_, _, err := r.Register(ctx, "bot-1", nil, fail)
+	require.ErrorIs(t, err, failed)
+	_, err = r.LookupBot(ctx, "bot-1")
+	require.ErrorIs(t, err, ErrNotFound, "a new bot is not kept")
+
+	old, token, err := r.Register(ctx, "bot-1", []byte("old"), func(context.Context, Registration, string) error { return nil })
+	require.NoError(t, err)
+	_, _, err = r.Register(ctx, "bot-1", []byte("new"), fail)
+	require.ErrorIs(t, err, failed)
+
+	got, err := r.Lookup(ctx, old.WebhookID)
+	require.NoError(t, err)
+	require.Equal(t, []byte("old"), got.EncryptedAPIKey)
+	require.True(t, r.VerifyToken(got, old.WebhookID, token), "a known bot keeps its token")
+}
+
+func TestRegistry_registerStoresAfterApply(t *testing.T) {
+	ctx := context.Background()
+	r := New(NewMemoryStore(), "salt", 10, time.Minute)
+	installed := func(context.Context, Registration, string) error { return nil }
+
+	old, oldToken, err := r.Register(ctx, "bot-1", []byte("old"), installed)
+	require.NoError(t, err)
+
+	rotated := make(chan error, 1)
+	reg, token, err := r.Register(ctx, "bot-1", []byte("new"), func(ctx context.Context, reg Registration, token string) error {
+		got, err := r.LookupBot(ctx, "bot-1")
+		require.NoError(t, err)
+		require.True(t, r.VerifyToken(got, old.WebhookID, oldToken), "the old token works until setWebhook succeeded")
+
+		go func() {
+			_, _, err := r.Rotate(ctx, "bot-1", time.Minute, installed)
+			rotated <- err
+		}()
+		select {
+		case <-rotated:
+			t.Fatal("Rotate ran while Register was applying")
+		case <-time.After(20 * time.Millisecond):
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, <-rotated)
+
+	got, err := r.LookupBot(ctx, "bot-1")
+	require.NoError(t, err)
+	require.NotNil(t, got.Previous)
+	require.Equal(t, reg.SecretTokenHash, got.Previous.SecretTokenHash, "Rotate started from the registered token")
+	require.True(t, r.VerifyToken(got, old.WebhookID, token))
+	require.Empty(t, r.bots.locks, "unused bot locks are dropped")
+}
+
+func TestRegistry_cache(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	r := New(store, "salt", 10, time.Minute)
+
+	reg, _, err := r.Register(ctx, "bot-1", nil, nil)
+	require.NoError(t, err)
+
+	// Served from the cache even though the store no longer has it.
+	require.NoError(t, store.Delete(ctx, reg.WebhookID))
+	_, err = r.Lookup(ctx, reg.WebhookID)
+	require.NoError(t, err)
+
+	reg, _, err = r.Register(ctx, "bot-2", nil, nil)
+	require.NoError(t, err)
+	_, err = r.Unregister(ctx, "bot-2")
+	require.NoError(t, err)
+	_, err = r.Lookup(ctx, reg.WebhookID)
+	require.ErrorIs(t, err, ErrNotFound, "unregistering must evict the cached entry")
+}
+
+func TestRegistry_rotate(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	r := New(NewMemoryStore(), "salt", 10, time.Minute)
+	r.now = func() time.Time { return now }
+
+	old, oldToken, err := r.Register(ctx, "bot-1", nil, nil)
+	require.NoError(t, err)
+	_, err = r.Lookup(ctx, old.WebhookID) // warm the cache
+	require.NoError(t, err)
+
+	var applied string
+	reg, token, err := r.Rotate(ctx, "bot-1", time.Hour, func(_ context.Context, reg Registration, token string) error {
+		applied = token
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, token, applied)
+	require.Equal(t, WebhookID(token, "salt"), reg.WebhookID)
+	require.Equal(t, old.WebhookID, reg.Previous.WebhookID)
+
+	// Both IDs are served during the grace period.
+	got, err := r.Lookup(ctx, reg.WebhookID)
+	require.NoError(t, err)
+	require.True(t, r.VerifyToken(got, reg.WebhookID, token))
+	require.False(t, r.VerifyToken(got, reg.WebhookID, oldToken))
+
+	got, err = r.Lookup(ctx, old.WebhookID)
+	require.NoError(t, err)
+	require.True(t, r.VerifyToken(got, old.WebhookID, oldToken))
+	require.True(t, r.VerifyToken(got, old.WebhookID, token))
+
+	// Past the grace period the old ID is refused, then retired.
+	now = now.Add(time.Hour)
+	_, err = r.Lookup(ctx, old.WebhookID)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	n, err := r.RetireExpired(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	got, err = r.LookupBot(ctx, "bot-1")
+	require.NoError(t, err)
+	require.Nil(t, got.Previous)
+
+	_, _, err = r.Rotate(ctx, "bot-2", time.Hour, nil)
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRegistry_rotateRollsBack(t *testing.T) {
+	ctx := context.Background()
+	r := New(NewMemoryStore(), "salt", 10, time.Minute)
+
+	old, token, err := r.Register(ctx, "bot-1", nil, nil)
+	require.NoError(t, err)
+
+	var rotated Registration
+	_, _, err = r.Rotate(ctx, "bot-1", time.Hour, func(_ context.Context, reg Registration, _ string) error {
+		rotated = reg
+		return errors.New("setWebhook failed")
+	})
+	require.ErrorContains(t, err, "setWebhook failed")
+
+	got, err := r.Lookup(ctx, old.WebhookID)
+	require.NoError(t, err)
+	require.True(t, r.VerifyToken(got, old.WebhookID, token))
+	require.Nil(t, got.Previous)
+
+	_, err = r.Lookup(ctx, rotated.WebhookID)
+	require.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,159 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by a RegistrationStore for unknown webhooks and bots.
+var ErrNotFound = errors.New("registration not found")
+
+// Status tells whether a registered bot's webhook is served.
+type Status string
+
+const (
+	StatusActive   Status = "active"
+	StatusDisabled Status = "disabled"
+)
+
+// Registration binds a bot to its webhook. The secret token itself is never
+// stored, only its salted hash.
+type Registration struct {
+	WebhookID string `json:"webhook_id"`
+	BotID     string `json:"bot_id"`
+	// SecretTokenHash is WebhookID(token, salt) of the token Telegram sends
+	// in X-Telegram-Bot-Api-Secret-Token.
+	SecretTokenHash string `json:"secret_token_hash"`
+	// EncryptedAPIKey is api_key_bot as received, encrypted with the payload key.
+	EncryptedAPIKey []byte `json:"encrypted_api_key"`
+	// Overrides adjusts processing for this bot; its AllowedUpdates also
+	// replace TELEGRAM_ALLOWED_UPDATES in setWebhook.
+	Overrides Overrides `json:"overrides"`
+	Status    Status    `json:"status"`
+	// Previous is the webhook the last token rotation replaced, kept until
+	// its grace period ends.
+	Previous  *RetiredWebhook `json:"previous,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// RetiredWebhook is a webhook ID replaced by a token rotation. It is still
+// served until ExpiresAt so deliveries Telegram already queued for the old
+// URL are not lost.
+type RetiredWebhook struct {
+	WebhookID       string    `json:"webhook_id"`
+	SecretTokenHash string    `json:"secret_token_hash"`
+	ExpiresAt       time.Time `json:"expires_at"`
+}
+
+// Active reports whether webhooks for the registration are accepted.
+func (r Registration) Active() bool {
+	return r.Status == StatusActive
+}
+
+// RegistrationStore persists registrations, indexed by webhook ID and bot ID.
+// A registration is also found by its Previous webhook ID.
+type RegistrationStore interface {
+	Get(ctx context.Context, webhookID string) (Registration, error)
+	GetByBot(ctx context.Context, botID string) (Registration, error)
+	// Put inserts or replaces the registration of reg.BotID. Webhook IDs the
+	// bot was registered under before and no longer uses are removed.
+	Put(ctx context.Context, reg Registration) error
+	// Delete removes the registration found under webhookID.
+	Delete(ctx context.Context, webhookID string) error
+	List(ctx context.Context) ([]Registration, error)
+	Close() error
+}
+
+// MemoryStore keeps registrations in process memory, e.g. for tests.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	byWebhook map[string]Registration
+	byBot     map[string]string
+	// previous maps a rotated-out webhook ID to the current one.
+	previous map[string]string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		byWebhook: map[string]Registration{},
+		byBot:     map[string]string{},
+		previous:  map[string]string{},
+	}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, webhookID string) (Registration, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.get(webhookID)
+}
+
+func (s *MemoryStore) get(webhookID string) (Registration, error) {
+	if current, ok := s.previous[webhookID]; ok {
+		webhookID = current
+	}
+	reg, ok := s.byWebhook[webhookID]
+	if !ok {
+		return Registration{}, ErrNotFound
+	}
+	return reg, nil
+}
+
+func (s *MemoryStore) GetByBot(ctx context.Context, botID string) (Registration, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	webhookID, ok := s.byBot[botID]
+	if !ok {
+		return Registration{}, ErrNotFound
+	}
+	return s.byWebhook[webhookID], nil
+}
+
+func (s *MemoryStore) Put(ctx context.Context, reg Registration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.byBot[reg.BotID]; ok {
+		s.remove(s.byWebhook[old])
+	}
+	s.byWebhook[reg.WebhookID] = reg
+	s.byBot[reg.BotID] = reg.WebhookID
+	if reg.Previous != nil {
+		s.previous[reg.Previous.WebhookID] = reg.WebhookID
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, webhookID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reg, err := s.get(webhookID)
+	if err != nil {
+		return err
+	}
+	s.remove(reg)
+	return nil
+}
+
+func (s *MemoryStore) remove(reg Registration) {
+	delete(s.byWebhook, reg.WebhookID)
+	delete(s.byBot, reg.BotID)
+	if reg.Previous != nil {
+		delete(s.previous, reg.Previous.WebhookID)
+	}
+}
+
+func (s *MemoryStore) List(ctx context.Context) ([]Registration, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Registration, 0, len(s.byWebhook))
+	for _, reg := range s.byWebhook {
+		out = append(out, reg)
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,96 @@
+package registry
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"murmapp.hook/internal/ratelimit"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.db")
+	s, err := OpenBoltStore(path)
+	require.NoError(t, err)
+	testStore(t, s)
+
+	// Registrations survive a restart.
+	require.NoError(t, s.Put(context.Background(), Registration{WebhookID: "w9", BotID: "bot-9", Status: StatusActive}))
+	require.NoError(t, s.Close())
+	s, err = OpenBoltStore(path)
+	require.NoError(t, err)
+	defer s.Close()
+	reg, err := s.GetByBot(context.Background(), "bot-9")
+	require.NoError(t, err)
+	require.Equal(t, "w9", reg.WebhookID)
+}
+
+func testStore(t *testing.T, s RegistrationStore) {
+	t.Helper()
+	ctx := context.Background()
+
+	_, err := s.Get(ctx, "w1")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	reg := Registration{
+		WebhookID:       "w1",
+		BotID:           "bot-1",
+		SecretTokenHash: "hash",
+		EncryptedAPIKey: []byte{1, 2, 3},
+		Overrides: Overrides{
+			ExtraRules:     []string{"message.contact.phone_number"},
+			AllowedUpdates: []string{"message"},
+			RateLimit:      &ratelimit.Limit{Rate: 5, Burst: 10},
+		},
+		Status:    StatusActive,
+		CreatedAt: time.Unix(1000, 0).UTC(),
+	}
+	require.NoError(t, s.Put(ctx, reg))
+
+	got, err := s.Get(ctx, "w1")
+	require.NoError(t, err)
+	require.Equal(t, reg, got)
+
+	got, err = s.GetByBot(ctx, "bot-1")
+	require.NoError(t, err)
+	require.Equal(t, "w1", got.WebhookID)
+
+	// Moving the bot to another webhook ID drops the old one.
+	reg.WebhookID = "w2"
+	require.NoError(t, s.Put(ctx, reg))
+	_, err = s.Get(ctx, "w1")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	all, err := s.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+
+	// A rotated-out webhook ID resolves to the current registration until
+	// the bot is stored without it.
+	reg.WebhookID = "w3"
+	reg.Previous = &RetiredWebhook{WebhookID: "w2", SecretTokenHash: "hash", ExpiresAt: time.Unix(2000, 0).UTC()}
+	require.NoError(t, s.Put(ctx, reg))
+	got, err = s.Get(ctx, "w2")
+	require.NoError(t, err)
+	require.Equal(t, reg, got)
+
+	all, err = s.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+
+	reg.Previous = nil
+	require.NoError(t, s.Put(ctx, reg))
+	_, err = s.Get(ctx, "w2")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, s.Delete(ctx, "w3"))
+	_, err = s.GetByBot(ctx, "bot-1")
+	require.ErrorIs(t, err, ErrNotFound)
+	require.ErrorIs(t, s.Delete(ctx, "w3"), ErrNotFound)
+}
@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"murmapp.hook/internal/config"
+	"murmapp.hook/internal/server"
+)
+
+// RotateToken implements `hook rotate-token [flags] <bot_id>`. It asks a
+// running hook, through its admin API, to rotate the bot's secret token,
+// since only that process can open the registration store. ADMIN_TOKEN and
+// ADMIN_PORT are read like the hook reads them, from -config or CONFIG_FILE
+// and the environment.
+func RotateToken(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("rotate-token", flag.ContinueOnError)
+	fs.StringVar(&config.ConfigFile, "config", "", "YAML config file (default $CONFIG_FILE); environment variables override it")
+	adminURL := fs.String("admin-url", "", "base URL of the hook's admin listener (default http://127.0.0.1:ADMIN_PORT)")
+	grace := fs.Duration("grace", 0, "how long the old webhook ID stays valid (default REGISTRY_ROTATION_GRACE of the hook)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: hook rotate-token [flags] <bot_id>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("rotate-token takes exactly one bot_id")
+	}
+
+	admin, err := config.LoadAdminConfig()
+	if err != nil {
+		return err
+	}
+	if admin.Token == "" {
+		return fmt.Errorf("ADMIN_TOKEN must be set")
+	}
+	if *adminURL == "" {
+		*adminURL = "http://127.0.0.1:" + admin.Port
+	}
+
+	endpoint := strings.TrimRight(*adminURL, "/") + "/admin/bots/" + url.PathEscape(fs.Arg(0)) + "/rotate"
+	if *grace > 0 {
+		endpoint += "?grace=" + url.QueryEscape(grace.String())
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+admin.Token)
+
+	// setWebhook and getWebhookInfo run within the request.
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("rotate-token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("rotate-token: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var rotation server.Rotation
+	if err := json.NewDecoder(resp.Body).Decode(&rotation); err != nil {
+		return fmt.Errorf("rotate-token: decode response: %w", err)
+	}
+	fmt.Fprintf(out, "bot_id=%s webhook_id=%s\nprevious webhook_id=%s accepted until %s\n",
+		rotation.BotID, rotation.WebhookID, rotation.PreviousWebhookID,
+		rotation.PreviousExpiresAt.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,57 @@
+package internal_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"murmapp.hook/internal"
+	"murmapp.hook/internal/config"
+)
+
+func TestRotateToken(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/admin/bots/bot-1/rotate", r.URL.Path)
+		require.Equal(t, "30m0s", r.URL.Query().Get("grace"))
+		require.Equal(t, "Bearer s3cret", r.Header.Get("Authorization"))
+		w.Write([]byte(`{"bot_id":"bot-1","webhook_id":"new","previous_webhook_id":"old","previous_expires_at":"2025-01-01T00:00:00Z"}`))
+	}))
+	defer admin.Close()
+	t.Setenv("ADMIN_TOKEN", "s3cret")
+
+	var out bytes.Buffer
+	err := internal.RotateToken([]string{"-admin-url", admin.URL, "-grace", "30m", "bot-1"}, &out)
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "webhook_id=new")
+	require.Contains(t, out.String(), "previous webhook_id=old accepted until 2025-01-01T00:00:00Z")
+
+	err = internal.RotateToken([]string{"-admin-url", admin.URL}, &out)
+	require.ErrorContains(t, err, "exactly one bot_id")
+}
+
+func TestRotateToken_configFile(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer from-file", r.Header.Get("Authorization"))
+		w.Write([]byte(`{"bot_id":"bot-1","webhook_id":"new"}`))
+	}))
+	defer admin.Close()
+	u, err := url.Parse(admin.URL)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "hook.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("server:\n  admin_port: \""+u.Port()+"\"\n  admin_token: from-file\n"), 0o600))
+	t.Setenv("ADMIN_TOKEN", "")
+	os.Unsetenv("ADMIN_TOKEN")
+	t.Setenv("ADMIN_PORT", "")
+	os.Unsetenv("ADMIN_PORT")
+	defer func() { config.ConfigFile = "" }()
+
+	var out bytes.Buffer
+	require.NoError(t, internal.RotateToken([]string{"-config", path, "bot-1"}, &out))
+	require.Contains(t, out.String(), "webhook_id=new")
+}
@@ -2,103 +2,364 @@ package internal
 
 import (
 	"context"
+	"crypto/ed25519"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
-	"github.com/eugene-ruby/xconnect/rabbitmq"
-	"github.com/streadway/amqp"
+	"murmapp.hook/internal/broker"
+	"murmapp.hook/internal/cache"
+	"murmapp.hook/internal/compression"
 	"murmapp.hook/internal/config"
+	"murmapp.hook/internal/dedupe"
+	"murmapp.hook/internal/envelope"
 	"murmapp.hook/internal/rabbitmqinit"
+	"murmapp.hook/internal/ratelimit"
+	"murmapp.hook/internal/registry"
 	"murmapp.hook/internal/server"
+	"murmapp.hook/internal/telegram"
 	"murmapp.hook/internal/webhook"
+	"murmapp.hook/pkg/hooksig"
 )
 
-// AppRabbitMQ manages RabbitMQ connection and channel lifecycle.
-type AppRabbitMQ struct {
-	conn    *amqp.Connection
-	rawCh   *amqp.Channel
-	channel rabbitmq.Channel
-}
-
-// Close gracefully shuts down the AMQP connection and channel.
-func (a *AppRabbitMQ) Close() {
-	if a.rawCh != nil {
-		_ = a.rawCh.Close()
-	}
-	if a.conn != nil {
-		_ = a.conn.Close()
-	}
-}
-
-// Run initializes configuration, connects to RabbitMQ,
+// Run initializes configuration, connects to the message broker,
 // loads privacy keys, starts the HTTP server, and blocks until shutdown.
 func Run() error {
 	conf, err := config.LoadConfig()
 	if err != nil {
 		return err
 	}
+	log.Printf("🔑 master key loaded from %s", conf.Encryption.MasterKeySource)
+	// Deferred first so it runs last, once everything using the keys is done.
+	defer conf.Encryption.Destroy()
 
-	rmq, ch, err := initRabbitMQ(*conf)
+	pub, err := broker.Open(conf.Broker.URL, conf.RabbitMQ.Topology.Exchange)
 	if err != nil {
 		return err
 	}
-	defer Shutdown(rmq)
+	defer Shutdown(pub)
 
-	if err := rabbitmqinit.DeclareExchanges(ch); err != nil {
-		return err
+	// Queues and bindings are only managed by the hook on RabbitMQ;
+	// other brokers are expected to be provisioned out of band.
+	if amqpPub, ok := pub.(*broker.AMQPPublisher); ok {
+		if err := rabbitmqinit.DeclareTopology(amqpPub.Channel(), conf.RabbitMQ.Topology); err != nil {
+			return err
+		}
 	}
 
 	if err := webhook.LoadPrivacyKeys(); err != nil {
 		return err // changed from fatal to return for testability
 	}
+	if len(conf.PrivacyRules) > 0 {
+		webhook.SetPrivacyKeys(conf.PrivacyRules)
+		log.Printf("⚙️ using %d privacy rule(s) from the configuration", len(conf.PrivacyRules))
+	}
+
+	store, err := initDedupe(conf.Dedupe)
+	if err != nil {
+		return err
+	}
 
 	// Listen for OS signals to handle graceful shutdown
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	// Start the webhook HTTP server in a background goroutine
-	go func() {
-		h := &server.OutboundHandler{
-			Channel: ch,
-			Config:  *conf,
+	wh := &webhook.OutboundHandler{
+		Publisher: pub,
+		Config:    *conf,
+		Dedupe:    store,
+		XIDCache:  initXIDCache(conf.XIDCache),
+		AuthGuard: initAuthGuard(conf.AuthGuard),
+	}
+	initRateLimit(wh, conf.RateLimit)
+	sealer, err := envelope.NewSealer(conf.Encryption.PayloadKeyring, conf.Encryption.PayloadAlgorithm, conf.Encryption.DataKeyTTL)
+	if err != nil {
+		return err
+	}
+	defer sealer.Destroy()
+	if conf.Encryption.LockSecrets {
+		if err := sealer.Lock(); err != nil {
+			return fmt.Errorf("SECRETS_MLOCK: data key: %w", err)
+		}
+	}
+	wh.Sealer = sealer
+	wh.Recipients = conf.Encryption.CasterRecipients
+	if conf.Encryption.SigningKey != nil {
+		wh.Signer = hooksig.NewSigner(ed25519.PrivateKey(conf.Encryption.SigningKey.Bytes()))
+		wh.Exchange = broker.DefaultExchange(conf.Broker.URL, conf.RabbitMQ.Topology.Exchange)
+		log.Printf("🔑 signing published messages, key_id=%s", wh.Signer.KeyID())
+	} else {
+		log.Println("⚠️ SIGNING_KEY not set, published messages are unsigned")
+	}
+	if codec := conf.Encryption.PayloadCompression; codec != "" {
+		if wh.Compressor, err = compression.New(codec, conf.Encryption.CompressionMinBytes); err != nil {
+			return err
+		}
+	}
+	if conf.BotOverridesFile != "" {
+		overrides, err := registry.LoadOverrides(conf.BotOverridesFile)
+		if err != nil {
+			return err
+		}
+		wh.Overrides = overrides
+		log.Printf("⚙️ loaded overrides for %d webhook(s)", len(overrides))
+	}
+	installer := initInstaller(conf)
+
+	// background tracks every goroutine that uses the keys or the registry;
+	// Run waits for it before the deferred reg.Close and Destroy.
+	var background sync.WaitGroup
+	if conf.Registry.Enabled {
+		consumer, ok := pub.(broker.Consumer)
+		if !ok {
+			return fmt.Errorf("REGISTRY_ENABLED requires a broker that supports consuming (amqp)")
+		}
+		reg, err := initRegistry(conf)
+		if err != nil {
+			return err
+		}
+		defer reg.Close()
+		wh.Registry = reg
+		rc := &registry.Consumer{
+			Registry:   wh.Registry,
+			Publisher:  pub,
+			APIKeyKey:  conf.Encryption.PayloadEncryptionKey.Bytes(),
+			ReplyTopic: conf.Registry.ReplyTopic,
+			Installer:  installer,
+			Signer:     wh.Signer,
+			Exchange:   wh.Exchange,
+		}
+		background.Add(2)
+		go func() {
+			defer background.Done()
+			if err := consumer.Consume(ctx, conf.Registry.Queue, rc.Handle); err != nil {
+				log.Printf("Registry consumer error: %v", err)
+				cancel()
+			}
+		}()
+		go func() {
+			defer background.Done()
+			retireRotatedWebhooks(ctx, reg, time.Minute)
+		}()
+	}
+	if conf.Ingest.Async() {
+		wh.Pipeline = webhook.NewPipeline(wh.Process, conf.Ingest.QueueSize, conf.Ingest.Workers, conf.Ingest.DrainTimeout)
+		wh.Pipeline.Start()
+	}
+
+	h := &server.OutboundHandler{
+		Webhook:   wh,
+		Installer: installer,
+		Config:    *conf,
+	}
+	if conf.Encryption.PayloadKeyringFile != "" {
+		h.ReloadKeys = func() (string, error) {
+			return reloadPayloadKeyring(conf.Encryption)
+		}
+	}
+	if conf.Encryption.CasterKeysFile != "" {
+		h.ReloadRecipients = func() ([]string, error) {
+			return reloadCasterRecipients(conf.Encryption, wh)
 		}
+	}
+	go watchKeyExpiry(ctx, conf.Encryption.PayloadKeyring)
+	if h.ReloadKeys != nil || h.ReloadRecipients != nil {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		go reloadOnHangup(ctx, hup, h)
+	}
+
+	// Start the webhook HTTP server in a background goroutine. The servers
+	// return once their Shutdown has, and Run waits for that, so requests in
+	// flight finish (within the shutdown timeout) before the keys are destroyed.
+	background.Add(2)
+	go func() {
+		defer background.Done()
 		if err := server.StartHookServer(ctx, h); err != nil {
 			log.Printf("Hook server error: %v", err)
 			cancel()
 		}
 	}()
 
+	go func() {
+		defer background.Done()
+		if err := server.StartAdminServer(ctx, h); err != nil {
+			log.Printf("Admin server error: %v", err)
+			cancel()
+		}
+	}()
+
 	// Wait until shutdown signal is received
 	<-ctx.Done()
+	background.Wait()
+
+	// Drain queued updates before the deferred broker shutdown closes the publisher.
+	if wh.Pipeline != nil {
+		wh.Pipeline.Shutdown()
+	}
 	log.Println("✅ app shut down cleanly")
 	return nil
 }
 
-// initRabbitMQ establishes a RabbitMQ connection and returns both
-// the raw AMQP channel and a wrapped xconnect-compatible channel.
-func initRabbitMQ(cfg config.Config) (*AppRabbitMQ, rabbitmq.Channel, error) {
-	conn, err := amqp.Dial(cfg.RabbitMQ.URL)
+// initDedupe picks the dedupe backend: shared Redis-compatible store when
+// configured, otherwise a per-process LRU window. It returns nil when disabled.
+func initDedupe(cfg config.DedupeConfig) (dedupe.Store, error) {
+	if cfg.WindowSize == 0 {
+		return nil, nil
+	}
+	if cfg.RedisURL != "" {
+		return dedupe.NewRedisStore(cfg.RedisURL, cfg.TTL, cfg.RedisTimeout)
+	}
+	return dedupe.NewMemoryStore(cfg.WindowSize, cfg.TTL), nil
+}
+
+// initXIDCache returns nil when the cache is disabled.
+func initXIDCache(cfg config.XIDCacheConfig) *cache.LRU[string, struct{}] {
+	if cfg.Size == 0 {
+		return nil
+	}
+	return cache.NewLRU[string, struct{}](cfg.Size, cfg.TTL)
+}
+
+// initAuthGuard returns nil when the lockout is disabled.
+func initAuthGuard(cfg config.AuthGuardConfig) *webhook.AuthGuard {
+	if cfg.MaxFailures == 0 {
+		return nil
+	}
+	return webhook.NewAuthGuard(cfg.MaxFailures, cfg.Window, cfg.Lockout)
+}
+
+// initRateLimit always installs a limiter so per-bot rate overrides apply
+// even when the service-wide rates are unset (unlimited).
+func initRateLimit(wh *webhook.OutboundHandler, cfg config.RateLimitConfig) {
+	wh.RateLimiter = ratelimit.NewLimiter(
+		ratelimit.Limit{Rate: cfg.GlobalRate, Burst: cfg.GlobalBurst},
+		ratelimit.Limit{Rate: cfg.WebhookRate, Burst: cfg.WebhookBurst},
+	)
+	wh.ShedTypes = map[string]bool{}
+	for _, t := range cfg.ShedTypes {
+		wh.ShedTypes[t] = true
+	}
+}
+
+// initRegistry opens the registration store: a bbolt file when
+// REGISTRY_PATH is set, otherwise process memory.
+func initRegistry(conf *config.Config) (*registry.Registry, error) {
+	var store registry.RegistrationStore = registry.NewMemoryStore()
+	if conf.Registry.Path != "" {
+		bolt, err := registry.OpenBoltStore(conf.Registry.Path)
+		if err != nil {
+			return nil, err
+		}
+		store = bolt
+	} else {
+		log.Println("⚠️ REGISTRY_PATH not set, registrations are kept in memory only")
+	}
+	return registry.New(store, string(conf.Encryption.SecretSalt.Bytes()), conf.Registry.CacheSize, conf.Registry.CacheTTL), nil
+}
+
+// retireRotatedWebhooks periodically forgets webhook IDs whose rotation grace
+// period is over. Lookups refuse them on time regardless; this keeps the
+// store from accumulating them.
+func retireRotatedWebhooks(ctx context.Context, reg *registry.Registry, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := reg.RetireExpired(ctx)
+			if err != nil {
+				log.Printf("[registry] ❌ retiring rotated webhooks: %v", err)
+			} else if n > 0 {
+				log.Printf("[registry] 🗑️ retired %d rotated-out webhook ID(s)", n)
+			}
+		}
+	}
+}
+
+// reloadPayloadKeyring re-reads PAYLOAD_KEYRING_FILE into the running
+// keyring, so a new active key takes effect without a restart. An invalid
+// file leaves the current keys in place.
+func reloadPayloadKeyring(enc config.EncryptionConfig) (string, error) {
+	active, keys, err := config.ReadPayloadKeyring(enc.PayloadKeyringFile)
 	if err != nil {
-		return nil, nil, err
+		return "", err
 	}
+	// The keyring copies the keys and zeroes the ones they replace.
+	err = enc.PayloadKeyring.Set(active, keys)
+	config.ClearKeys(keys)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("🔑 payload keyring reloaded, active key_id=%s, %d key(s)", active, len(keys))
+	return active, nil
+}
 
-	ch, err := conn.Channel()
+// reloadCasterRecipients re-reads CASTER_KEYS_FILE into the running
+// recipient set. The XID cache is reset so IDs seen before are re-emitted for
+// recipients that were just added.
+func reloadCasterRecipients(enc config.EncryptionConfig, wh *webhook.OutboundHandler) ([]string, error) {
+	recipients, err := config.ReadCasterKeys(enc.CasterKeysFile, enc.EphemeralKeyTTL)
 	if err != nil {
-		_ = conn.Close()
-		return nil, nil, err
+		return nil, err
 	}
+	if err := enc.CasterRecipients.Set(recipients); err != nil {
+		return nil, err
+	}
+	wh.ResetXIDCache()
+	ids := enc.CasterRecipients.KeyIDs()
+	log.Printf("🔑 caster recipient keys reloaded, key_ids=%s", strings.Join(ids, ","))
+	return ids, nil
+}
 
-	wrapped := rabbitmq.WrapAMQPChannel(ch)
-	return &AppRabbitMQ{
-		conn:    conn,
-		rawCh:   ch,
-		channel: wrapped,
-	}, wrapped, nil
+// reloadOnHangup reloads every key file on SIGHUP. A file that fails to load
+// leaves its current keys in place.
+func reloadOnHangup(ctx context.Context, hup <-chan os.Signal, h *server.OutboundHandler) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if h.ReloadKeys != nil {
+				if _, err := h.ReloadKeys(); err != nil {
+					log.Printf("❌ keyring reload failed, keeping current keys: %v", err)
+				}
+			}
+			if h.ReloadRecipients != nil {
+				if _, err := h.ReloadRecipients(); err != nil {
+					log.Printf("❌ recipient keys reload failed, keeping current keys: %v", err)
+				}
+			}
+		}
+	}
 }
 
-// Shutdown safely closes RabbitMQ resources.
-func Shutdown(rmq *AppRabbitMQ) {
-	rmq.Close()
+// initInstaller returns nil unless PUBLIC_URL tells where Telegram should
+// deliver updates.
+func initInstaller(conf *config.Config) *registry.Installer {
+	if conf.Telegram.PublicURL == "" {
+		return nil
+	}
+	return &registry.Installer{
+		Client:         telegram.NewClient(conf.Telegram.APIURL, conf.Telegram.Timeout),
+		APIKeyKey:      conf.Encryption.PayloadEncryptionKey.Bytes(),
+		BaseURL:        strings.TrimRight(conf.Telegram.PublicURL, "/") + "/" + strings.Trim(conf.WebhookPath, "/"),
+		AllowedUpdates: conf.Telegram.AllowedUpdates,
+		MaxConnections: conf.Telegram.MaxConnections,
+	}
+}
+
+// Shutdown safely closes broker resources.
+func Shutdown(pub broker.Publisher) {
+	if err := pub.Close(); err != nil {
+		log.Printf("broker close: %v", err)
+	}
 }
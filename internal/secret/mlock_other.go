@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd)
+
+package secret
+
+import "errors"
+
+func mlock([]byte) error {
+	return errors.New("not supported on this platform")
+}
+
+func munlock([]byte) {}
@@ -0,0 +1,13 @@
+//go:build linux || darwin || freebsd
+
+package secret
+
+import "syscall"
+
+func mlock(b []byte) error {
+	return syscall.Mlock(b)
+}
+
+func munlock(b []byte) {
+	syscall.Munlock(b)
+}
@@ -0,0 +1,97 @@
+// Package secret holds decrypted key material. A Bytes is shared by pointer
+// instead of copied, prints as [REDACTED], can be locked into memory so it is
+// not swapped out, and is zeroed by Destroy.
+package secret
+
+import (
+	"fmt"
+	"sync"
+)
+
+const redacted = "[REDACTED]"
+
+// noCopy makes go vet's copylocks check report copies of a Bytes.
+type noCopy struct{}
+
+func (*noCopy) Lock()   {}
+func (*noCopy) Unlock() {}
+
+// Bytes is a piece of key material. Use it through a pointer; copying the
+// struct is reported by go vet.
+type Bytes struct {
+	_ noCopy
+
+	mu     sync.Mutex
+	b      []byte
+	locked bool
+}
+
+// New takes ownership of b: the caller must not keep or reuse it, since
+// Destroy zeroes it in place.
+func New(b []byte) *Bytes {
+	return &Bytes{b: b}
+}
+
+// Bytes returns the key material. The slice is the secret's own memory, so
+// it must not be modified or retained past Destroy.
+func (s *Bytes) Bytes() []byte {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b
+}
+
+// Len returns the length of the key material.
+func (s *Bytes) Len() int {
+	return len(s.Bytes())
+}
+
+// Lock locks the key material into memory so it is never written to swap.
+// It fails where mlock is unsupported or RLIMIT_MEMLOCK is too low.
+func (s *Bytes) Lock() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locked || len(s.b) == 0 {
+		return nil
+	}
+	if err := mlock(s.b); err != nil {
+		return fmt.Errorf("mlock: %w", err)
+	}
+	s.locked = true
+	return nil
+}
+
+// Destroy zeroes the key material and unlocks it. The secret is empty
+// afterwards; Destroy is safe to call more than once.
+func (s *Bytes) Destroy() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.b)
+	if s.locked {
+		munlock(s.b)
+		s.locked = false
+	}
+	s.b = nil
+}
+
+// String, GoString, Format and MarshalJSON keep the key material out of
+// logs, %v/%+v/%#v of structs holding it, and JSON dumps.
+
+func (s *Bytes) String() string   { return redacted }
+func (s *Bytes) GoString() string { return redacted }
+
+func (s *Bytes) Format(f fmt.State, _ rune) {
+	f.Write([]byte(redacted))
+}
+
+func (s *Bytes) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + redacted + `"`), nil
+}
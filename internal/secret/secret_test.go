@@ -0,0 +1,53 @@
+package secret
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBytes_redacted(t *testing.T) {
+	s := New([]byte("super-secret-key"))
+	holder := struct {
+		Key  *Bytes
+		Name string
+	}{Key: s, Name: "payload"}
+
+	for _, verb := range []string{"%v", "%+v", "%#v", "%s", "%q", "%x"} {
+		out := fmt.Sprintf(verb, holder)
+		require.NotContains(t, out, "super-secret-key", verb)
+		require.NotContains(t, out, "73757065722d", verb)
+		require.Contains(t, out, redacted, verb)
+	}
+
+	data, err := json.Marshal(holder)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"Key":"[REDACTED]","Name":"payload"}`, string(data))
+}
+
+func TestBytes_destroy(t *testing.T) {
+	raw := []byte("super-secret-key")
+	s := New(raw)
+	require.Equal(t, 16, s.Len())
+
+	s.Destroy()
+	require.Equal(t, make([]byte, 16), raw, "the original memory is zeroed")
+	require.Nil(t, s.Bytes())
+	s.Destroy()
+
+	var none *Bytes
+	require.Nil(t, none.Bytes())
+	require.NoError(t, none.Lock())
+	none.Destroy()
+}
+
+func TestBytes_lock(t *testing.T) {
+	s := New([]byte("super-secret-key"))
+	if err := s.Lock(); err != nil {
+		t.Skipf("mlock unavailable here: %v", err)
+	}
+	require.NoError(t, s.Lock(), "locking twice is a no-op")
+	s.Destroy()
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"murmapp.hook/internal/metrics"
+	"murmapp.hook/internal/registry"
+	"murmapp.hook/internal/webhook"
+)
+
+// StartAdminServer serves operational endpoints on a separate port that is
+// not meant to be exposed through the public ingress.
+func StartAdminServer(ctx context.Context, h *OutboundHandler) error {
+	return serve(ctx, "admin", ":"+h.Config.AdminPort, adminRouter(h))
+}
+
+// adminRouter exposes /metrics unauthenticated and mounts /admin only when
+// ADMIN_TOKEN is configured; admin calls must send it as a bearer token.
+func adminRouter(h *OutboundHandler) http.Handler {
+	r := chi.NewRouter()
+	r.Handle("/metrics", metrics.Handler())
+
+	if h.Config.AdminToken == "" {
+		log.Println("🔒 ADMIN_TOKEN not set, admin endpoints disabled")
+		return r
+	}
+
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(requireAdminToken(h.Config.AdminToken))
+
+		r.Post("/xid-cache/reset", func(w http.ResponseWriter, r *http.Request) {
+			h.Webhook.ResetXIDCache()
+			log.Println("[admin] ♻️ XID cache reset, Telegram IDs will be re-emitted")
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		r.Get("/auth/lockouts", func(w http.ResponseWriter, r *http.Request) {
+			lockouts := []webhook.Lockout{}
+			if h.Webhook.AuthGuard != nil {
+				lockouts = h.Webhook.AuthGuard.Lockouts()
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(lockouts)
+		})
+
+		r.Delete("/auth/lockouts/{ip}", func(w http.ResponseWriter, r *http.Request) {
+			if h.Webhook.AuthGuard == nil {
+				http.Error(w, "auth lockout disabled", http.StatusNotFound)
+				return
+			}
+			ip := chi.URLParam(r, "ip")
+			h.Webhook.AuthGuard.Unblock(ip)
+			log.Printf("[admin] 🔓 lockout lifted for IP=%s", ip)
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		r.Post("/keys/reload", func(w http.ResponseWriter, r *http.Request) {
+			if h.ReloadKeys == nil {
+				http.Error(w, "PAYLOAD_KEYRING_FILE not set", http.StatusNotFound)
+				return
+			}
+			active, err := h.ReloadKeys()
+			if err != nil {
+				log.Printf("[admin] ❌ keyring reload failed: %v", err)
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"active_key_id": active})
+		})
+
+		r.Post("/recipients/reload", func(w http.ResponseWriter, r *http.Request) {
+			if h.ReloadRecipients == nil {
+				http.Error(w, "CASTER_KEYS_FILE not set", http.StatusNotFound)
+				return
+			}
+			ids, err := h.ReloadRecipients()
+			if err != nil {
+				log.Printf("[admin] ❌ recipient keys reload failed: %v", err)
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string][]string{"key_ids": ids})
+		})
+
+		r.Delete("/bots/{bot_id}", func(w http.ResponseWriter, r *http.Request) {
+			deregisterBot(w, r, h)
+		})
+
+		r.Post("/bots/{bot_id}/rotate", func(w http.ResponseWriter, r *http.Request) {
+			rotateBotToken(w, r, h)
+		})
+	})
+	return r
+}
+
+// deregisterBot deletes the bot's Telegram webhook before forgetting it, so
+// Telegram stops delivering to an ID the hook would refuse.
+func deregisterBot(w http.ResponseWriter, r *http.Request, h *OutboundHandler) {
+	reg := h.Webhook.Registry
+	if reg == nil {
+		http.Error(w, "registry disabled", http.StatusNotFound)
+		return
+	}
+
+	botID := chi.URLParam(r, "bot_id")
+	bot, err := reg.LookupBot(r.Context(), botID)
+	if errors.Is(err, registry.ErrNotFound) {
+		http.Error(w, "bot not registered", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "registration store unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	if h.Installer != nil {
+		if err := h.Installer.Uninstall(r.Context(), bot); err != nil {
+			log.Printf("[admin] ❌ deleteWebhook failed for bot_id=%s: %v", botID, err)
+			http.Error(w, "deleteWebhook failed", http.StatusBadGateway)
+			return
+		}
+	}
+
+	if _, err := reg.Unregister(r.Context(), botID); err != nil {
+		log.Printf("[admin] ❌ failed to remove bot_id=%s: %v", botID, err)
+		http.Error(w, "registration store unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	log.Printf("[admin] 🗑️ bot_id=%s deregistered", botID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Rotation is the answer of POST /admin/bots/{bot_id}/rotate. The new secret
+// token only goes to Telegram and is never returned.
+type Rotation struct {
+	BotID             string    `json:"bot_id"`
+	WebhookID         string    `json:"webhook_id"`
+	PreviousWebhookID string    `json:"previous_webhook_id"`
+	PreviousExpiresAt time.Time `json:"previous_expires_at"`
+}
+
+// rotateBotToken moves a bot to a new secret token and webhook ID. The old
+// ID stays valid for ?grace= (default REGISTRY_ROTATION_GRACE), so updates
+// Telegram already queued for it are still accepted.
+func rotateBotToken(w http.ResponseWriter, r *http.Request, h *OutboundHandler) {
+	reg := h.Webhook.Registry
+	if reg == nil {
+		http.Error(w, "registry disabled", http.StatusNotFound)
+		return
+	}
+	if h.Installer == nil {
+		http.Error(w, "PUBLIC_URL not set, cannot call setWebhook", http.StatusConflict)
+		return
+	}
+
+	grace := h.Config.Registry.RotationGrace
+	if v := r.URL.Query().Get("grace"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "grace must be a positive duration", http.StatusBadRequest)
+			return
+		}
+		grace = d
+	}
+
+	botID := chi.URLParam(r, "bot_id")
+	bot, _, err := reg.Rotate(r.Context(), botID, grace, h.Installer.Install)
+	switch {
+	case errors.Is(err, registry.ErrNotFound):
+		http.Error(w, "bot not registered", http.StatusNotFound)
+		return
+	case err != nil:
+		// Most likely setWebhook; the registration has been rolled back.
+		log.Printf("[admin] ❌ token rotation failed for bot_id=%s: %v", botID, err)
+		http.Error(w, "token rotation failed", http.StatusBadGateway)
+		return
+	}
+
+	log.Printf("[admin] 🔄 bot_id=%s rotated to webhook_id=%s, previous retires at %s",
+		botID, bot.WebhookID, bot.Previous.ExpiresAt.Format(time.RFC3339))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Rotation{
+		BotID:             bot.BotID,
+		WebhookID:         bot.WebhookID,
+		PreviousWebhookID: bot.Previous.WebhookID,
+		PreviousExpiresAt: bot.Previous.ExpiresAt,
+	})
+}
+
+func requireAdminToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
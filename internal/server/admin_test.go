@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eugene-ruby/xencryptor/xsecrets"
+	"github.com/stretchr/testify/require"
+	"murmapp.hook/internal/cache"
+	"murmapp.hook/internal/config"
+	"murmapp.hook/internal/registry"
+	"murmapp.hook/internal/telegram"
+	"murmapp.hook/internal/webhook"
+)
+
+func TestAdminRouter_xidCacheReset(t *testing.T) {
+	xids := cache.NewLRU[string, struct{}](10, time.Hour)
+	xids.Add("xid", struct{}{})
+
+	h := &OutboundHandler{
+		Webhook: &webhook.OutboundHandler{XIDCache: xids},
+		Config:  config.Config{AdminToken: "s3cret"},
+	}
+	router := adminRouter(h)
+
+	req := httptest.NewRequest("POST", "/admin/xid-cache/reset", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Equal(t, 1, xids.Len())
+
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, 0, xids.Len())
+}
+
+func TestAdminRouter_disabledWithoutToken(t *testing.T) {
+	h := &OutboundHandler{Webhook: &webhook.OutboundHandler{}}
+	router := adminRouter(h)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/admin/xid-cache/reset", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestAdminRouter_authLockouts(t *testing.T) {
+	guard := webhook.NewAuthGuard(1, time.Minute, time.Hour)
+	guard.Fail("1.2.3.4")
+
+	h := &OutboundHandler{
+		Webhook: &webhook.OutboundHandler{AuthGuard: guard},
+		Config:  config.Config{AdminToken: "s3cret"},
+	}
+	router := adminRouter(h)
+
+	req := httptest.NewRequest("GET", "/admin/auth/lockouts", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var lockouts []webhook.Lockout
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &lockouts))
+	require.Len(t, lockouts, 1)
+	require.Equal(t, "1.2.3.4", lockouts[0].IP)
+
+	req = httptest.NewRequest("DELETE", "/admin/auth/lockouts/1.2.3.4", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Empty(t, guard.Lockouts())
+}
+
+func TestAdminRouter_deregisterBot(t *testing.T) {
+	key := []byte("payload-32-byte-key-abc123456789")
+	apiKey, err := xsecrets.EncryptBytesWithKey([]byte("123:abc"), key)
+	require.NoError(t, err)
+
+	reg := registry.New(registry.NewMemoryStore(), "salt", 10, time.Minute)
+	bot, _, err := reg.Register(context.Background(), "bot-1", apiKey, nil)
+	require.NoError(t, err)
+
+	var deleted bool
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deleted = r.URL.Path == "/bot123:abc/deleteWebhook"
+		w.Write([]byte(`{"ok":true,"result":true}`))
+	}))
+	defer api.Close()
+
+	h := &OutboundHandler{
+		Webhook:   &webhook.OutboundHandler{Registry: reg},
+		Installer: &registry.Installer{Client: telegram.NewClient(api.URL, time.Second), APIKeyKey: key},
+		Config:    config.Config{AdminToken: "s3cret"},
+	}
+	router := adminRouter(h)
+
+	req := httptest.NewRequest("DELETE", "/admin/bots/bot-1", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.True(t, deleted)
+
+	_, err = reg.Lookup(context.Background(), bot.WebhookID)
+	require.ErrorIs(t, err, registry.ErrNotFound)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAdminRouter_rotateBotToken(t *testing.T) {
+	key := []byte("payload-32-byte-key-abc123456789")
+	apiKey, err := xsecrets.EncryptBytesWithKey([]byte("123:abc"), key)
+	require.NoError(t, err)
+
+	reg := registry.New(registry.NewMemoryStore(), "salt", 10, time.Minute)
+	bot, _, err := reg.Register(context.Background(), "bot-1", apiKey, nil)
+	require.NoError(t, err)
+
+	var webhookURL string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bot123:abc/setWebhook":
+			var params telegram.SetWebhookParams
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&params))
+			webhookURL = params.URL
+			w.Write([]byte(`{"ok":true,"result":true}`))
+		case "/bot123:abc/getWebhookInfo":
+			w.Write([]byte(`{"ok":true,"result":{"url":"` + webhookURL + `"}}`))
+		}
+	}))
+	defer api.Close()
+
+	h := &OutboundHandler{
+		Webhook: &webhook.OutboundHandler{Registry: reg},
+		Installer: &registry.Installer{
+			Client:    telegram.NewClient(api.URL, time.Second),
+			APIKeyKey: key,
+			BaseURL:   "https://hook.example.com/api/webhook",
+		},
+		Config: config.Config{
+			AdminToken: "s3cret",
+			Registry:   config.RegistryConfig{RotationGrace: time.Hour},
+		},
+	}
+	router := adminRouter(h)
+
+	req := httptest.NewRequest("POST", "/admin/bots/bot-1/rotate?grace=5m", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var rotation Rotation
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&rotation))
+	require.Equal(t, bot.WebhookID, rotation.PreviousWebhookID)
+	require.NotEqual(t, bot.WebhookID, rotation.WebhookID)
+	require.Equal(t, "https://hook.example.com/api/webhook/"+rotation.WebhookID, webhookURL)
+	require.WithinDuration(t, time.Now().Add(5*time.Minute), rotation.PreviousExpiresAt, time.Minute)
+
+	_, err = reg.Lookup(context.Background(), bot.WebhookID)
+	require.NoError(t, err, "the old webhook ID is served during the grace period")
+
+	req = httptest.NewRequest("POST", "/admin/bots/bot-2/rotate", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAdminRouter_reloadKeys(t *testing.T) {
+	h := &OutboundHandler{
+		Webhook: &webhook.OutboundHandler{},
+		Config:  config.Config{AdminToken: "s3cret"},
+	}
+	req := httptest.NewRequest("POST", "/admin/keys/reload", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+
+	rec := httptest.NewRecorder()
+	adminRouter(h).ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	h.ReloadKeys = func() (string, error) { return "2025-07", nil }
+	rec = httptest.NewRecorder()
+	adminRouter(h).ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"active_key_id":"2025-07"}`, rec.Body.String())
+}
+
+func TestAdminRouter_reloadRecipients(t *testing.T) {
+	h := &OutboundHandler{
+		Webhook: &webhook.OutboundHandler{},
+		Config:  config.Config{AdminToken: "s3cret"},
+	}
+	req := httptest.NewRequest("POST", "/admin/recipients/reload", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+
+	rec := httptest.NewRecorder()
+	adminRouter(h).ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	h.ReloadRecipients = func() ([]string, error) { return nil, errors.New("recipients: at least one recipient key is required") }
+	rec = httptest.NewRecorder()
+	adminRouter(h).ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	h.ReloadRecipients = func() ([]string, error) { return []string{"caster", "audit"}, nil }
+	rec = httptest.NewRecorder()
+	adminRouter(h).ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"key_ids":["caster","audit"]}`, rec.Body.String())
+}
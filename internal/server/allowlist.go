@@ -0,0 +1,32 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"net/netip"
+
+	"murmapp.hook/internal/clientip"
+	"murmapp.hook/internal/metrics"
+)
+
+var allowlistRejected = metrics.NewCounter(
+	"hook_ip_allowlist_rejected_total",
+	"Webhook requests refused because the client IP is outside the allowlist.",
+)
+
+// allowlist refuses requests whose client address, as resolved by
+// clientip.Resolver.Middleware, is not within one of the allowed prefixes.
+func allowlist(allowed []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr, ok := clientip.FromContext(r.Context())
+			if !ok || !clientip.Contains(allowed, addr) {
+				allowlistRejected.Inc()
+				log.Printf("[hook] 🚫 request from IP=%s outside the allowlist", clientip.String(r))
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"murmapp.hook/internal/config"
+	"murmapp.hook/internal/webhook"
+)
+
+func TestHookRouter_allowlist(t *testing.T) {
+	// Lock the Telegram address out so a request that clears the allowlist is
+	// recognisable by the handler's 429.
+	guard := webhook.NewAuthGuard(1, time.Minute, time.Hour)
+	guard.Fail("149.154.167.1")
+
+	h := &OutboundHandler{
+		Webhook: &webhook.OutboundHandler{AuthGuard: guard},
+		Config: config.Config{
+			WebhookPath: "/hook",
+			ClientIP: config.ClientIPConfig{
+				AllowlistEnabled: true,
+				Allowlist:        []netip.Prefix{netip.MustParsePrefix("149.154.160.0/20")},
+				TrustedProxies:   []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			},
+		},
+	}
+	router := hookRouter(h)
+
+	req := httptest.NewRequest("POST", "/hook/abc", nil)
+	req.RemoteAddr = "203.0.113.7:1234"
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	// A spoofed header from an untrusted peer does not get through.
+	req.Header.Set("X-Forwarded-For", "149.154.167.1")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	// Behind the load balancer the forwarded address is both allowlisted and
+	// the one the handler sees.
+	req.RemoteAddr = "10.0.0.2:1234"
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	req = httptest.NewRequest("GET", "/healthz", nil)
+	req.RemoteAddr = "203.0.113.7:1234"
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+}
@@ -2,41 +2,69 @@ package server
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
-	"fmt"
 
-	"github.com/eugene-ruby/xconnect/rabbitmq"
 	"github.com/go-chi/chi/v5"
+	"murmapp.hook/internal/clientip"
 	"murmapp.hook/internal/config"
+	"murmapp.hook/internal/registry"
 	"murmapp.hook/internal/webhook"
 )
 
 type OutboundHandler struct {
-	Channel rabbitmq.Channel
-	Config  config.Config
+	Webhook *webhook.OutboundHandler
+	// Installer manages Telegram webhooks of registered bots; nil when
+	// PUBLIC_URL is not configured.
+	Installer *registry.Installer
+	Config    config.Config
+	// ReloadKeys re-reads the payload keyring and returns the active key
+	// ID; nil when PAYLOAD_KEYRING_FILE is not configured.
+	ReloadKeys func() (string, error)
+	// ReloadRecipients re-reads the caster recipient keys and returns their
+	// IDs; nil when CASTER_KEYS_FILE is not configured.
+	ReloadRecipients func() ([]string, error)
 }
 
 func StartHookServer(ctx context.Context, h *OutboundHandler) error {
-	addr := ":" + h.Config.AppPort
+	return serve(ctx, "hook", ":"+h.Config.AppPort, hookRouter(h))
+}
 
+// hookRouter serves the Telegram webhook behind client IP resolution and,
+// when enabled, the source allowlist. /healthz stays reachable for probes.
+func hookRouter(h *OutboundHandler) http.Handler {
 	r := chi.NewRouter()
 	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("ok"))
 	})
 
-	path := fmt.Sprintf("%s/{webhook_id}", h.Config.WebhookPath)
-	r.Post(path, func(w http.ResponseWriter, r *http.Request) {
-		webhook.HandleWebhook(w, r, &webhook.OutboundHandler{ Channel: h.Channel, Config: h.Config })
+	r.Group(func(r chi.Router) {
+		r.Use(clientip.NewResolver(h.Config.ClientIP.TrustedProxies).Middleware)
+		if h.Config.ClientIP.AllowlistEnabled {
+			r.Use(allowlist(h.Config.ClientIP.Allowlist))
+		}
+
+		// Every method reaches HandleWebhook, which answers all but POST
+		// with 405 and an Allow header.
+		path := fmt.Sprintf("%s/{webhook_id}", h.Config.WebhookPath)
+		r.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			webhook.HandleWebhook(w, r, h.Webhook)
+		})
 	})
 
+	return r
+}
+
+// serve runs an HTTP server until ctx is done or a shutdown signal arrives.
+func serve(ctx context.Context, name, addr string, handler http.Handler) error {
 	srv := &http.Server{
 		Addr:    addr,
-		Handler: r,
+		Handler: handler,
 	}
 
 	// Channel for receiving system signals
@@ -50,7 +78,7 @@ func StartHookServer(ctx context.Context, h *OutboundHandler) error {
 		case <-ctx.Done():
 		}
 
-		log.Println("🌐 shutting down hook server...")
+		log.Printf("🌐 shutting down %s server...", name)
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
@@ -60,7 +88,7 @@ func StartHookServer(ctx context.Context, h *OutboundHandler) error {
 		close(idleConnsClosed)
 	}()
 
-	log.Printf("🌐 Starting hook server on %s...", addr)
+	log.Printf("🌐 Starting %s server on %s...", name, addr)
 	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
 		return err
 	}
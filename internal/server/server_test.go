@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"murmapp.hook/internal/config"
+	"murmapp.hook/internal/webhook"
+)
+
+func TestHookRouter_rejectsWrongMethod(t *testing.T) {
+	h := &OutboundHandler{
+		Webhook: &webhook.OutboundHandler{},
+		Config:  config.Config{WebhookPath: "/hook"},
+	}
+	router := hookRouter(h)
+
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		req := httptest.NewRequest(method, "/hook/abc", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusMethodNotAllowed, rec.Code, method)
+		require.Equal(t, http.MethodPost, rec.Header().Get("Allow"), method)
+	}
+}
@@ -0,0 +1,140 @@
+// Package telegram is a minimal Bot API client for managing webhooks.
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the public Bot API endpoint.
+const DefaultBaseURL = "https://api.telegram.org"
+
+// Client calls Bot API methods. The bot token is part of every request URL,
+// so errors returned by the client never include the URL.
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// NewClient creates a client for baseURL, e.g. DefaultBaseURL or a local
+// Bot API server.
+func NewClient(baseURL string, timeout time.Duration) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: timeout},
+	}
+}
+
+// SetWebhookParams are the setWebhook arguments the hook uses.
+type SetWebhookParams struct {
+	URL                string   `json:"url"`
+	SecretToken        string   `json:"secret_token,omitempty"`
+	AllowedUpdates     []string `json:"allowed_updates,omitempty"`
+	MaxConnections     int      `json:"max_connections,omitempty"`
+	DropPendingUpdates bool     `json:"drop_pending_updates,omitempty"`
+}
+
+// WebhookInfo is the result of getWebhookInfo.
+type WebhookInfo struct {
+	URL                  string   `json:"url"`
+	HasCustomCertificate bool     `json:"has_custom_certificate"`
+	PendingUpdateCount   int      `json:"pending_update_count"`
+	LastErrorDate        int64    `json:"last_error_date,omitempty"`
+	LastErrorMessage     string   `json:"last_error_message,omitempty"`
+	MaxConnections       int      `json:"max_connections,omitempty"`
+	AllowedUpdates       []string `json:"allowed_updates,omitempty"`
+}
+
+// APIError is a response with "ok": false.
+type APIError struct {
+	Method      string
+	Code        int
+	Description string
+	// RetryAfter is set when Telegram asks to back off (error 429).
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("telegram %s: %d %s", e.Method, e.Code, e.Description)
+}
+
+type response struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// SetWebhook points the bot's updates at p.URL.
+func (c *Client) SetWebhook(ctx context.Context, botToken string, p SetWebhookParams) error {
+	return c.call(ctx, botToken, "setWebhook", p, nil)
+}
+
+// GetWebhookInfo returns the bot's current webhook state.
+func (c *Client) GetWebhookInfo(ctx context.Context, botToken string) (WebhookInfo, error) {
+	var info WebhookInfo
+	err := c.call(ctx, botToken, "getWebhookInfo", struct{}{}, &info)
+	return info, err
+}
+
+// DeleteWebhook removes the bot's webhook.
+func (c *Client) DeleteWebhook(ctx context.Context, botToken string, dropPendingUpdates bool) error {
+	params := struct {
+		DropPendingUpdates bool `json:"drop_pending_updates,omitempty"`
+	}{dropPendingUpdates}
+	return c.call(ctx, botToken, "deleteWebhook", params, nil)
+}
+
+func (c *Client) call(ctx context.Context, botToken, method string, params, result any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("telegram %s: %w", method, err)
+	}
+
+	endpoint := c.baseURL + "/bot" + botToken + "/" + method
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("telegram %s: invalid request", method)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		// *url.Error carries the URL, and with it the bot token.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("telegram %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	var r response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return fmt.Errorf("telegram %s: unexpected response (HTTP %d): %w", method, resp.StatusCode, err)
+	}
+	if !r.OK {
+		return &APIError{
+			Method:      method,
+			Code:        r.ErrorCode,
+			Description: r.Description,
+			RetryAfter:  time.Duration(r.Parameters.RetryAfter) * time.Second,
+		}
+	}
+	if result != nil {
+		if err := json.Unmarshal(r.Result, result); err != nil {
+			return fmt.Errorf("telegram %s: decode result: %w", method, err)
+		}
+	}
+	return nil
+}
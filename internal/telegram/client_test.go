@@ -0,0 +1,90 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// stubBotAPI records setWebhook calls and reports them back via getWebhookInfo.
+func stubBotAPI(t *testing.T) (*httptest.Server, *SetWebhookParams) {
+	t.Helper()
+	current := &SetWebhookParams{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/bot123:abc/setWebhook", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(current))
+		w.Write([]byte(`{"ok":true,"result":true,"description":"Webhook was set"}`))
+	})
+	mux.HandleFunc("/bot123:abc/getWebhookInfo", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"ok": true,
+			"result": WebhookInfo{
+				URL:            current.URL,
+				MaxConnections: current.MaxConnections,
+				AllowedUpdates: current.AllowedUpdates,
+			},
+		})
+	})
+	mux.HandleFunc("/bot123:abc/deleteWebhook", func(w http.ResponseWriter, r *http.Request) {
+		*current = SetWebhookParams{}
+		w.Write([]byte(`{"ok":true,"result":true}`))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"ok":false,"error_code":401,"description":"Unauthorized"}`))
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, current
+}
+
+func TestClient_webhookLifecycle(t *testing.T) {
+	srv, current := stubBotAPI(t)
+	c := NewClient(srv.URL+"/", time.Second)
+	ctx := context.Background()
+
+	err := c.SetWebhook(ctx, "123:abc", SetWebhookParams{
+		URL:            "https://hook.example.com/api/webhook/xyz",
+		SecretToken:    "s3cret",
+		AllowedUpdates: []string{"message"},
+		MaxConnections: 40,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "s3cret", current.SecretToken)
+
+	info, err := c.GetWebhookInfo(ctx, "123:abc")
+	require.NoError(t, err)
+	require.Equal(t, "https://hook.example.com/api/webhook/xyz", info.URL)
+	require.Equal(t, []string{"message"}, info.AllowedUpdates)
+
+	require.NoError(t, c.DeleteWebhook(ctx, "123:abc", false))
+	info, err = c.GetWebhookInfo(ctx, "123:abc")
+	require.NoError(t, err)
+	require.Empty(t, info.URL)
+}
+
+func TestClient_apiError(t *testing.T) {
+	srv, _ := stubBotAPI(t)
+	c := NewClient(srv.URL, time.Second)
+
+	err := c.SetWebhook(context.Background(), "999:wrong", SetWebhookParams{URL: "https://x"})
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, 401, apiErr.Code)
+	require.NotContains(t, err.Error(), "999:wrong")
+}
+
+func TestClient_transportErrorHidesToken(t *testing.T) {
+	c := NewClient("http://127.0.0.1:1", time.Second)
+
+	err := c.DeleteWebhook(context.Background(), "123:abc", true)
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "123:abc")
+}
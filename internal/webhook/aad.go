@@ -0,0 +1,38 @@
+package webhook
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	hookpb "murmapp.hook/proto"
+)
+
+// aadVersion is the associated data scheme of published payloads, see
+// PayloadAssociatedData.
+const aadVersion = 1
+
+const aadLabel = "hook.TelegramWebhookPayload/1"
+
+// PayloadAssociatedData returns the associated data p's encrypted_payload is
+// bound to under p's aad_version. Binding webhook_id, update_id and
+// received_at_unix means a ciphertext cannot be moved into another payload
+// message without failing to decrypt.
+func PayloadAssociatedData(p *hookpb.TelegramWebhookPayload) ([]byte, error) {
+	switch p.GetAadVersion() {
+	case 0:
+		return nil, nil
+	case 1:
+		aad := []byte(aadLabel)
+		aad = appendField(aad, []byte(p.GetWebhookId()))
+		aad = appendField(aad, binary.BigEndian.AppendUint64(nil, uint64(p.GetUpdateId())))
+		aad = appendField(aad, binary.BigEndian.AppendUint64(nil, uint64(p.GetReceivedAtUnix())))
+		return aad, nil
+	default:
+		return nil, fmt.Errorf("unsupported aad_version %d", p.GetAadVersion())
+	}
+}
+
+func appendField(b, field []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(field)))
+	return append(b, field...)
+}
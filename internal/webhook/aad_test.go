@@ -0,0 +1,29 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	hookpb "murmapp.hook/proto"
+)
+
+func TestPayloadAssociatedData(t *testing.T) {
+	p := &hookpb.TelegramWebhookPayload{WebhookId: "ab", UpdateId: 1, ReceivedAtUnix: 2, AadVersion: 1}
+
+	aad, err := PayloadAssociatedData(p)
+	require.NoError(t, err)
+	want := []byte("hook.TelegramWebhookPayload/1" +
+		"\x00\x00\x00\x02ab" +
+		"\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00\x00\x01" +
+		"\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00\x00\x02")
+	require.Equal(t, want, aad)
+
+	p.AadVersion = 0
+	aad, err = PayloadAssociatedData(p)
+	require.NoError(t, err)
+	require.Nil(t, aad, "no associated data for version 0")
+
+	p.AadVersion = 9
+	_, err = PayloadAssociatedData(p)
+	require.Error(t, err, "unknown aad_version is rejected")
+}
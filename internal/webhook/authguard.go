@@ -0,0 +1,130 @@
+package webhook
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"murmapp.hook/internal/cache"
+	"murmapp.hook/internal/metrics"
+)
+
+// maxTrackedSources bounds memory spent on remembering failing clients.
+const maxTrackedSources = 100000
+
+var (
+	authFailures = metrics.NewCounter(
+		"hook_auth_failures_total",
+		"Webhook requests rejected because of an invalid secret token.",
+	)
+	authLockouts = metrics.NewCounter(
+		"hook_auth_lockouts_total",
+		"Source IPs blocked after too many invalid secret tokens.",
+	)
+	authLockedRejected = metrics.NewCounter(
+		"hook_auth_locked_rejected_total",
+		"Webhook requests answered with 429 because the source IP is blocked.",
+	)
+)
+
+// AuthGuard counts failed token checks per source IP and blocks a source
+// that fails maxFailures times within window for the lockout duration.
+type AuthGuard struct {
+	maxFailures int
+	window      time.Duration
+	lockout     time.Duration
+	now         func() time.Time
+	sources     *cache.LRU[string, *authState]
+}
+
+type authState struct {
+	mu          sync.Mutex
+	failures    int
+	firstFailed time.Time
+	lockedUntil time.Time
+}
+
+// Lockout describes a currently blocked source.
+type Lockout struct {
+	IP          string    `json:"ip"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+func NewAuthGuard(maxFailures int, window, lockout time.Duration) *AuthGuard {
+	return &AuthGuard{
+		maxFailures: maxFailures,
+		window:      window,
+		lockout:     lockout,
+		now:         time.Now,
+		sources:     cache.NewLRU[string, *authState](maxTrackedSources, window+lockout),
+	}
+}
+
+// Blocked reports whether ip is locked out and for how much longer.
+func (g *AuthGuard) Blocked(ip string) (time.Duration, bool) {
+	st, ok := g.sources.Get(ip)
+	if !ok {
+		return 0, false
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	remaining := st.lockedUntil.Sub(g.now())
+	if remaining <= 0 {
+		return 0, false
+	}
+	authLockedRejected.Inc()
+	return remaining, true
+}
+
+// Fail records a failed token check and reports whether ip is now locked out.
+func (g *AuthGuard) Fail(ip string) bool {
+	authFailures.Inc()
+
+	st, _ := g.sources.GetOrAdd(ip, &authState{})
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := g.now()
+	if st.failures == 0 || now.Sub(st.firstFailed) > g.window {
+		st.failures = 0
+		st.firstFailed = now
+	}
+	st.failures++
+
+	if st.failures >= g.maxFailures && now.After(st.lockedUntil) {
+		st.lockedUntil = now.Add(g.lockout)
+		// Keep the entry for the whole lockout even if the LRU TTL is shorter.
+		g.sources.Add(ip, st)
+		authLockouts.Inc()
+		return true
+	}
+	return false
+}
+
+// Succeed clears the failure history of ip.
+func (g *AuthGuard) Succeed(ip string) {
+	g.sources.Remove(ip)
+}
+
+// Unblock lifts a lockout early, e.g. from the admin API.
+func (g *AuthGuard) Unblock(ip string) {
+	g.sources.Remove(ip)
+}
+
+// Lockouts lists the sources that are currently blocked, soonest to expire first.
+func (g *AuthGuard) Lockouts() []Lockout {
+	now := g.now()
+	out := []Lockout{}
+	g.sources.Range(func(ip string, st *authState) bool {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		if st.lockedUntil.After(now) {
+			out = append(out, Lockout{IP: ip, Failures: st.failures, LockedUntil: st.lockedUntil})
+		}
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].LockedUntil.Before(out[j].LockedUntil) })
+	return out
+}
@@ -0,0 +1,57 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthGuard_lockout(t *testing.T) {
+	now := time.Unix(1000, 0)
+	g := NewAuthGuard(3, time.Minute, 10*time.Minute)
+	g.now = func() time.Time { return now }
+
+	require.False(t, g.Fail("1.1.1.1"))
+	require.False(t, g.Fail("1.1.1.1"))
+	_, blocked := g.Blocked("1.1.1.1")
+	require.False(t, blocked)
+
+	require.True(t, g.Fail("1.1.1.1"))
+	remaining, blocked := g.Blocked("1.1.1.1")
+	require.True(t, blocked)
+	require.Equal(t, 10*time.Minute, remaining)
+
+	lockouts := g.Lockouts()
+	require.Len(t, lockouts, 1)
+	require.Equal(t, "1.1.1.1", lockouts[0].IP)
+	require.Equal(t, 3, lockouts[0].Failures)
+
+	now = now.Add(11 * time.Minute)
+	_, blocked = g.Blocked("1.1.1.1")
+	require.False(t, blocked)
+	require.Empty(t, g.Lockouts())
+}
+
+func TestAuthGuard_windowResetsFailures(t *testing.T) {
+	now := time.Unix(1000, 0)
+	g := NewAuthGuard(2, time.Minute, time.Hour)
+	g.now = func() time.Time { return now }
+
+	require.False(t, g.Fail("1.1.1.1"))
+	now = now.Add(2 * time.Minute)
+	require.False(t, g.Fail("1.1.1.1"), "failures outside the window must not accumulate")
+}
+
+func TestAuthGuard_succeedAndUnblock(t *testing.T) {
+	g := NewAuthGuard(2, time.Minute, time.Hour)
+
+	g.Fail("1.1.1.1")
+	g.Succeed("1.1.1.1")
+	require.False(t, g.Fail("1.1.1.1"), "a valid token clears earlier failures")
+
+	require.True(t, g.Fail("1.1.1.1"))
+	g.Unblock("1.1.1.1")
+	_, blocked := g.Blocked("1.1.1.1")
+	require.False(t, blocked)
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 )
@@ -27,29 +28,68 @@ func LoadPrivacyKeys() error {
 	return nil
 }
 
+// SetPrivacyKeys replaces the embedded rules, e.g. with filter.rules from
+// the config file.
+func SetPrivacyKeys(rules []string) {
+	privacyKeys = append([]string(nil), rules...)
+}
+
 type FilterResult struct {
 	RedactedJSON []byte
 	Matched      int
 	TelegramIDs  []TelegramID
+	UpdateID     int64
 }
 
 type TelegramID struct {
-	TelegramXId string
+	TelegramXId    string
 	OpenTelegramID string
 }
 
+// ErrNoPrivacyKeysMatched is returned when none of the rules applied to an update.
+var ErrNoPrivacyKeysMatched = errors.New("no privacy keys matched")
+
+// PrivacyRules returns the embedded privacy-key paths without removed and
+// with extra appended, for bots that override them.
+func PrivacyRules(extra, removed []string) []string {
+	if len(extra) == 0 && len(removed) == 0 {
+		return privacyKeys
+	}
+	drop := make(map[string]bool, len(removed))
+	for _, r := range removed {
+		drop[r] = true
+	}
+	rules := make([]string, 0, len(privacyKeys)+len(extra))
+	seen := map[string]bool{}
+	for _, r := range append(append([]string{}, privacyKeys...), extra...) {
+		if !drop[r] && !seen[r] {
+			rules = append(rules, r)
+			seen[r] = true
+		}
+	}
+	return rules
+}
+
 // FilterPayload redacts sensitive data and encrypts IDs
 func FilterPayload(raw []byte, secretSalt string) (FilterResult, error) {
+	return FilterPayloadWithRules(raw, secretSalt, privacyKeys)
+}
+
+// FilterPayloadWithRules is FilterPayload with an explicit list of privacy-key paths.
+func FilterPayloadWithRules(raw []byte, secretSalt string, rules []string) (FilterResult, error) {
 	result := FilterResult{}
 	var obj map[string]interface{}
-	
+
 	if err := json.Unmarshal(raw, &obj); err != nil {
 		return result, fmt.Errorf("invalid JSON")
 	}
+	if v, ok := obj["update_id"].(float64); ok {
+		result.UpdateID = int64(v)
+	}
 
 	matched := 0
 	uniqXID := map[string]bool{}
-	for _, path := range privacyKeys {
+	for _, path := range rules {
 		parts := strings.Split(path, ".")
 		telegramID, res := applyPrivacyRule(obj, parts, secretSalt)
 		if res {
@@ -64,7 +104,7 @@ func FilterPayload(raw []byte, secretSalt string) (FilterResult, error) {
 	result.Matched = matched
 
 	if matched == 0 {
-		return FilterResult{}, fmt.Errorf("no privacy keys matched")
+		return FilterResult{UpdateID: result.UpdateID}, ErrNoPrivacyKeysMatched
 	}
 
 	r, err := json.Marshal(obj)
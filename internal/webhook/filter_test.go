@@ -4,6 +4,7 @@ import (
 	"strings"
 	"testing"
 )
+
 var secretSalt string
 
 func init() {
@@ -154,3 +155,41 @@ func TestFilterPayload_ChannelFieldsUnredacted(t *testing.T) {
 		t.Errorf("expected channel title and username to be preserved, got: %s", redactedStr)
 	}
 }
+
+func TestFilterPayload_UpdateID(t *testing.T) {
+	raw := []byte(`{"update_id": 912345678, "message": {"from": {"id": 1}}}`)
+
+	result, err := FilterPayload(raw, secretSalt)
+	if err != nil {
+		t.Fatalf("expected payload to pass filter, but got error: %s", err)
+	}
+	if result.UpdateID != 912345678 {
+		t.Errorf("expected update_id 912345678, got %d", result.UpdateID)
+	}
+}
+
+func TestFilterPayloadWithRules_overrides(t *testing.T) {
+	raw := []byte(`{
+		"message": {
+			"from": {"id": 123, "first_name": "Eugene"},
+			"contact": {"phone_number": "+100000000"}
+		}
+	}`)
+
+	rules := PrivacyRules([]string{"message.contact.phone_number"}, []string{"message.from.first_name"})
+	result, err := FilterPayloadWithRules(raw, secretSalt, rules)
+	if err != nil {
+		t.Fatalf("expected payload to pass filter, got: %s", err)
+	}
+
+	redactedStr := string(result.RedactedJSON)
+	if strings.Contains(redactedStr, "+100000000") {
+		t.Errorf("expected extra rule to redact phone number, got: %s", redactedStr)
+	}
+	if !strings.Contains(redactedStr, "Eugene") {
+		t.Errorf("expected removed rule to keep first_name, got: %s", redactedStr)
+	}
+	if len(PrivacyRules(nil, nil)) != len(privacyKeys) {
+		t.Errorf("expected no overrides to keep the embedded rules")
+	}
+}
@@ -1,90 +1,436 @@
 package webhook
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"math"
+	"mime"
 	"net/http"
+	"slices"
+	"strconv"
 	"time"
 
-	"github.com/eugene-ruby/xconnect/rabbitmq"
-	"github.com/eugene-ruby/xencryptor/xsecrets"
 	"github.com/go-chi/chi/v5"
 	"google.golang.org/protobuf/proto"
+	"murmapp.hook/internal/broker"
+	"murmapp.hook/internal/cache"
+	"murmapp.hook/internal/clientip"
+	"murmapp.hook/internal/compression"
 	"murmapp.hook/internal/config"
+	"murmapp.hook/internal/dedupe"
+	"murmapp.hook/internal/envelope"
+	"murmapp.hook/internal/hybrid"
+	"murmapp.hook/internal/metrics"
+	"murmapp.hook/internal/ratelimit"
+	"murmapp.hook/internal/registry"
+	"murmapp.hook/pkg/hooksig"
 	hookpb "murmapp.hook/proto"
 )
 
+// schemaVersion is bumped whenever the published protobuf layout changes incompatibly.
+const schemaVersion = "2"
+
+var duplicatesSuppressed = metrics.NewCounterVec(
+	"hook_duplicate_updates_suppressed_total",
+	"Telegram redeliveries answered with 200 without being republished.",
+	"webhook_id",
+)
+
+var (
+	rateLimited = metrics.NewCounterVec(
+		"hook_rate_limited_total",
+		"Updates answered with 429 because a rate limit was exceeded.",
+		"webhook_id",
+	)
+	updatesNotAllowed = metrics.NewCounterVec(
+		"hook_updates_not_allowed_total",
+		"Updates acknowledged and dropped because their type is not in the bot's allowed updates.",
+		"webhook_id",
+	)
+	updatesShed = metrics.NewCounterVec(
+		"hook_updates_shed_total",
+		"Low-priority updates acknowledged and dropped because a rate limit bucket ran low.",
+		"webhook_id",
+	)
+)
+
+var (
+	xidCacheHits = metrics.NewCounter(
+		"hook_xid_cache_hits_total",
+		"Telegram IDs skipped because their encrypted mapping was published recently.",
+	)
+	xidCacheMisses = metrics.NewCounter(
+		"hook_xid_cache_misses_total",
+		"Telegram IDs encrypted and published because they were not in the XID cache.",
+	)
+)
+
 type OutboundHandler struct {
-	Channel rabbitmq.Channel
-	Config  config.Config
+	Publisher broker.Publisher
+	Config    config.Config
+	// Dedupe suppresses redeliveries of an update_id; nil disables it.
+	Dedupe dedupe.Store
+	// XIDCache holds XIDs whose EncryptedTelegramID was published recently;
+	// nil disables it and every ID is published on every update.
+	XIDCache *cache.LRU[string, struct{}]
+	// Pipeline processes updates asynchronously after the HTTP response;
+	// nil processes them inline in the request goroutine.
+	Pipeline *Pipeline
+	// AuthGuard blocks source IPs that keep sending invalid tokens; nil disables it.
+	AuthGuard *AuthGuard
+	// RateLimiter answers 429 to updates over the global or per-webhook rate;
+	// nil disables rate limiting.
+	RateLimiter *ratelimit.Limiter
+	// ShedTypes are update types (e.g. "message_reaction") dropped first
+	// when a rate limit bucket runs low.
+	ShedTypes map[string]bool
+	// Registry rejects webhook IDs that no bot was registered for; nil
+	// accepts any token whose hash matches the URL.
+	Registry *registry.Registry
+	// Overrides from BOT_OVERRIDES_FILE by webhook ID; they take precedence
+	// over overrides stored with the registration.
+	Overrides map[string]registry.Overrides
+	// Sealer encrypts published payloads; nil encrypts them directly with
+	// Config.Encryption.PayloadEncryptionKey.
+	Sealer *envelope.Sealer
+	// Compressor shrinks payloads before encryption; nil disables it.
+	Compressor *compression.Compressor
+	// Recipients are the keys EncryptedTelegramID is encrypted for; nil uses
+	// RSA with Config.Encryption.CasterPublicRSAKey.
+	Recipients *hybrid.Recipients
+	// Signer adds Ed25519 signature headers to published messages; nil
+	// publishes them unsigned.
+	Signer *hooksig.Signer
+	// Exchange is the one Publisher publishes to by default, covered by the
+	// signature of messages without an exchange override.
+	Exchange string
+}
+
+// ResetXIDCache forgets all recently published XIDs, so every Telegram ID is
+// re-emitted the next time it is seen. Consumers that lost their XID mapping
+// use this through the admin API.
+func (h *OutboundHandler) ResetXIDCache() {
+	if h.XIDCache != nil {
+		h.XIDCache.Purge()
+	}
+}
+
+// Update is an authenticated webhook request waiting to be filtered,
+// encrypted and published.
+type Update struct {
+	WebhookID  string
+	RemoteIP   string
+	Body       []byte
+	ReceivedAt time.Time
+	Overrides  registry.Overrides
 }
 
 func HandleWebhook(w http.ResponseWriter, r *http.Request, h *OutboundHandler) {
 	webhookID := chi.URLParam(r, "webhook_id")
-	ip := r.RemoteAddr
+	ip := clientip.String(r)
+	defer r.Body.Close()
 
-	raw, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "can't read body", http.StatusBadRequest)
-		log.Printf("[hook] ❌ failed to read request body from %s: %v", ip, err)
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	defer r.Body.Close()
 
-	if !isAuthorizedWebhook(r, webhookID, h) {
+	if h.AuthGuard != nil {
+		if remaining, blocked := h.AuthGuard.Blocked(ip); blocked {
+			w.Header().Set("Retry-After", strconv.Itoa(int(remaining.Seconds())+1))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	// Unknown webhooks are turned away before the token is hashed.
+	var reg *registry.Registration
+	if h.Registry != nil {
+		found, err := h.Registry.Lookup(r.Context(), webhookID)
+		switch {
+		case errors.Is(err, registry.ErrNotFound) || (err == nil && !found.Active()):
+			// Not counted by the AuthGuard: a bot deleted or rotated past its
+			// grace keeps being retried by Telegram from shared addresses.
+			http.Error(w, "not found", http.StatusNotFound)
+			log.Printf("[hook] 🚨 unknown webhook_id from IP=%s, rejecting request", ip)
+			return
+		case err != nil:
+			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+			log.Printf("[hook] ❌ registration lookup failed: %v", err)
+			return
+		}
+		reg = &found
+	}
+
+	// The secret token travels in a header, so unauthenticated clients are
+	// rejected before a single byte of the body is read.
+	if !isAuthorizedWebhook(r, webhookID, reg, h) {
 		http.Error(w, "forbidden", http.StatusForbidden)
 		log.Printf("[hook] 🚨 token mismatch for IP=%s, rejecting request", ip)
+		if h.AuthGuard != nil && h.AuthGuard.Fail(ip) {
+			log.Printf("[hook] 🔒 IP=%s locked out after repeated token mismatches", ip)
+		}
 		return
 	}
+	if h.AuthGuard != nil {
+		h.AuthGuard.Succeed(ip)
+	}
 
-	result, err := FilterPayload(raw, string(h.Config.Encryption.SecretSalt))
-	log.Printf("[hook] 🔐 %d sensitive value(s) matched and processed in payload", result.Matched)
+	if !isJSONContentType(r.Header.Get("Content-Type")) {
+		http.Error(w, "unsupported media type", http.StatusUnsupportedMediaType)
+		log.Printf("[hook] ❌ unexpected content type %q from %s", r.Header.Get("Content-Type"), ip)
+		return
+	}
+
+	raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.Config.MaxBodyBytes))
 	if err != nil {
-		log.Printf("[hook] ❌ dropped payload from %s: %s", ip, err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			log.Printf("[hook] ❌ body from %s exceeds %d bytes", ip, tooLarge.Limit)
+			return
+		}
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		log.Printf("[hook] ❌ failed to read request body from %s: %v", ip, err)
+		return
+	}
+
+	overrides := h.overridesFor(webhookID, reg)
+	kind := ""
+	if len(overrides.AllowedUpdates) > 0 || len(h.ShedTypes) > 0 {
+		kind = updateType(raw)
+	}
+
+	if len(overrides.AllowedUpdates) > 0 && !slices.Contains(overrides.AllowedUpdates, kind) {
+		updatesNotAllowed.Inc(webhookID)
+		log.Printf("[hook] 🙈 dropped %q update from %s, not in the bot's allowed updates", kind, ip)
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	if err := publishWebhookPayload(webhookID, result.RedactedJSON, h); err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+	if !allowByRate(w, webhookID, ip, kind, overrides, h) {
 		return
 	}
 
-	publishTelegramIDs(result, h)
+	u := Update{WebhookID: webhookID, RemoteIP: ip, Body: raw, ReceivedAt: time.Now(), Overrides: overrides}
+
+	if h.Pipeline != nil {
+		switch err := h.Pipeline.Enqueue(u); {
+		case errors.Is(err, ErrQueueFull):
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			log.Printf("[hook] 🚦 ingest queue full, asking %s to retry", ip)
+		case err != nil:
+			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+		return
+	}
 
-	log.Printf("[hook] ✅ accepted webhook from %s, forwarded to MQ", ip)
+	if err := h.Process(r.Context(), u); err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
-func isAuthorizedWebhook(r *http.Request, webhookID string, h *OutboundHandler) bool {
+// Process filters, encrypts and publishes one update. It returns an error only
+// when the update was not published and Telegram should redeliver it; dropped
+// payloads and duplicates are not errors.
+func (h *OutboundHandler) Process(ctx context.Context, u Update) error {
+	rules := PrivacyRules(u.Overrides.ExtraRules, u.Overrides.RemovedRules)
+	result, err := FilterPayloadWithRules(u.Body, string(h.Config.Encryption.SecretSalt.Bytes()), rules)
+	log.Printf("[hook] 🔐 %d sensitive value(s) matched and processed in payload", result.Matched)
+	switch {
+	case errors.Is(err, ErrNoPrivacyKeysMatched) && u.Overrides.Unmatched == registry.UnmatchedForward:
+		// Nothing to redact; the bot asked for such updates to be published anyway.
+		result.RedactedJSON = u.Body
+	case err != nil:
+		log.Printf("[hook] ❌ dropped payload from %s: %s", u.RemoteIP, err)
+		return nil
+	}
+
+	if isDuplicate(ctx, u.WebhookID, result.UpdateID, h) {
+		duplicatesSuppressed.Inc(u.WebhookID)
+		log.Printf("[hook] ♻️ duplicate update_id=%d from %s, not republished", result.UpdateID, u.RemoteIP)
+		return nil
+	}
+
+	if err := publishWebhookPayload(ctx, u, result, h); err != nil {
+		forgetUpdate(ctx, u.WebhookID, result.UpdateID, h)
+		return err
+	}
+
+	publishTelegramIDs(ctx, u.WebhookID, result, h)
+
+	log.Printf("[hook] ✅ accepted webhook from %s, forwarded to MQ", u.RemoteIP)
+	return nil
+}
+
+// isAuthorizedWebhook checks the secret token against the registration when
+// there is one, otherwise against the webhook ID in the URL.
+func isAuthorizedWebhook(r *http.Request, webhookID string, reg *registry.Registration, h *OutboundHandler) bool {
 	token := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
-	expectedID := ComputeWebhookID(token, string(h.Config.Encryption.SecretSalt))
-	log.Printf("[hook] 📩 incoming from IP=%s | webhook_id=%s | token_len=%d | valid=%v", r.RemoteAddr, webhookID, len(token), expectedID == webhookID)
-	return expectedID == webhookID
+	if reg != nil {
+		return h.Registry.VerifyToken(*reg, webhookID, token)
+	}
+	expectedID := ComputeWebhookID(token, string(h.Config.Encryption.SecretSalt.Bytes()))
+	return subtle.ConstantTimeCompare([]byte(expectedID), []byte(webhookID)) == 1
+}
+
+// overridesFor combines the registration's overrides with those from the
+// overrides file.
+func (h *OutboundHandler) overridesFor(webhookID string, reg *registry.Registration) registry.Overrides {
+	var o registry.Overrides
+	if reg != nil {
+		o = reg.Overrides
+	}
+	if file, ok := h.Overrides[webhookID]; ok {
+		o = o.Merge(file)
+	}
+	return o
 }
 
-func publishWebhookPayload(webhookID string, redacted []byte, h *OutboundHandler) error {
-	encrypted, err := xsecrets.EncryptBytesWithKey(redacted, h.Config.Encryption.PayloadEncryptionKey)
+// allowByRate applies the rate limits and writes the response when the update
+// is refused. Shed low-priority updates are acknowledged with 200 so Telegram
+// does not retry them into the same overload.
+func allowByRate(w http.ResponseWriter, webhookID, ip, kind string, overrides registry.Overrides, h *OutboundHandler) bool {
+	if h.RateLimiter == nil {
+		return true
+	}
+
+	lowPriority := h.ShedTypes[kind]
+	var decision ratelimit.Decision
+	var wait time.Duration
+	if overrides.RateLimit != nil {
+		decision, wait = h.RateLimiter.AllowLimit(webhookID, *overrides.RateLimit, lowPriority)
+	} else {
+		decision, wait = h.RateLimiter.Allow(webhookID, lowPriority)
+	}
+	switch decision {
+	case ratelimit.Shed:
+		updatesShed.Inc(webhookID)
+		log.Printf("[hook] 🪶 shed low-priority update from %s under load", ip)
+		w.WriteHeader(http.StatusOK)
+		return false
+	case ratelimit.Limited:
+		rateLimited.Inc(webhookID)
+		log.Printf("[hook] 🚦 rate limit exceeded for webhook from %s", ip)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// updateType returns the name of the update's payload field, such as
+// "message" or "message_reaction".
+func updateType(raw []byte) string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return ""
+	}
+	for k := range fields {
+		if k != "update_id" {
+			return k
+		}
+	}
+	return ""
+}
+
+func isJSONContentType(v string) bool {
+	mediaType, _, err := mime.ParseMediaType(v)
+	return err == nil && mediaType == "application/json"
+}
+
+// isDuplicate records the update in the dedupe window and reports whether it
+// was already there. Store errors fail open: a duplicate publish is better
+// than a lost update.
+func isDuplicate(ctx context.Context, webhookID string, updateID int64, h *OutboundHandler) bool {
+	if h.Dedupe == nil || updateID == 0 {
+		return false
+	}
+	dup, err := h.Dedupe.Add(ctx, webhookID, updateID)
 	if err != nil {
-		log.Printf("[hook] ❌ encryption failed: %v", err)
-		return err
+		log.Printf("[hook] ⚠️ dedupe store unavailable: %v", err)
+		return false
+	}
+	return dup
+}
+
+// forgetUpdate removes the update from the dedupe window so Telegram's retry
+// of a failed request is not mistaken for a duplicate.
+func forgetUpdate(ctx context.Context, webhookID string, updateID int64, h *OutboundHandler) {
+	if h.Dedupe == nil || updateID == 0 {
+		return
+	}
+	if err := h.Dedupe.Remove(ctx, webhookID, updateID); err != nil {
+		log.Printf("[hook] ⚠️ failed to forget update_id=%d: %v", updateID, err)
 	}
+}
 
+func publishWebhookPayload(ctx context.Context, u Update, result FilterResult, h *OutboundHandler) error {
 	payload := &hookpb.TelegramWebhookPayload{
-		WebhookId:        webhookID,
-		EncryptedPayload: encrypted,
-		ReceivedAtUnix:   time.Now().Unix(),
+		WebhookId:      u.WebhookID,
+		ReceivedAtUnix: u.ReceivedAt.Unix(),
+		UpdateId:       result.UpdateID,
+	}
+	if h.Config.Encryption.PayloadAAD {
+		payload.AadVersion = aadVersion
+	}
+	aad, err := PayloadAssociatedData(payload)
+	if err != nil {
+		return err
 	}
 
+	data := result.RedactedJSON
+	if h.Compressor != nil {
+		if data, payload.Compression, err = h.Compressor.Compress(data); err != nil {
+			log.Printf("[hook] ❌ compression failed: %v", err)
+			return err
+		}
+	}
+
+	sealed, err := sealPayload(data, aad, h)
+	if err != nil {
+		log.Printf("[hook] ❌ encryption failed: %v", err)
+		return err
+	}
+	payload.EncryptedPayload = sealed.Ciphertext
+	payload.EncryptedDataKey = sealed.WrappedKey
+	payload.KeyId = sealed.KeyID
+	payload.Algorithm = sealed.Algorithm
+
 	msg, err := proto.Marshal(payload)
 	if err != nil {
 		log.Printf("[hook] ❌ failed to marshal payload: %v", err)
 		return err
 	}
 
-	if err := h.Channel.Publish("murmapp", "telegram.messages.in", msg); err != nil {
+	topic := "telegram.messages.in"
+	if u.Overrides.RoutingKey != "" {
+		topic = u.Overrides.RoutingKey
+	}
+
+	headers := messageHeaders(payload, MessageID(u.WebhookID, result.UpdateID), sealed.KeyID)
+	if h.Signer != nil {
+		exchange := u.Overrides.Exchange
+		if exchange == "" {
+			exchange = h.Exchange
+		}
+		h.Signer.Sign(headers, exchange, topic, msg)
+	}
+	if err := broker.PublishTo(ctx, h.Publisher, u.Overrides.Exchange, topic, headers, msg); err != nil {
 		log.Printf("[hook] ❌ failed to publish to MQ: %v", err)
 		return err
 	}
@@ -92,17 +438,61 @@ func publishWebhookPayload(webhookID string, redacted []byte, h *OutboundHandler
 	return nil
 }
 
-func publishTelegramIDs(result FilterResult, h *OutboundHandler) {
+func sealPayload(data, aad []byte, h *OutboundHandler) (envelope.Envelope, error) {
+	if h.Sealer != nil {
+		return h.Sealer.Seal(data, aad)
+	}
+	kek := envelope.Key{ID: h.Config.Encryption.PayloadKeyID, Material: h.Config.Encryption.PayloadEncryptionKey.Bytes()}
+	return envelope.SealWith(kek, envelope.AlgorithmDirect, data, aad)
+}
+
+func publishTelegramIDs(ctx context.Context, webhookID string, result FilterResult, h *OutboundHandler) {
+	recipients := h.Recipients
+	if recipients == nil {
+		keyID := h.Config.Encryption.CasterKeyID
+		var err error
+		recipients, err = hybrid.NewRecipients(hybrid.Recipient{
+			KeyID:     keyID,
+			Encrypter: &hybrid.RSAEncrypter{Key: h.Config.Encryption.CasterPublicRSAKey, KeyID: keyID},
+		})
+		if err != nil {
+			log.Printf("[hook] ❌ no caster key to encrypt telegram_id for: %v", err)
+			return
+		}
+	}
+
 	for _, id := range result.TelegramIDs {
-		encryptedID, err := xsecrets.RSAEncryptBytes(h.Config.Encryption.CasterPublicRSAKey, []byte(id.OpenTelegramID))
+		if h.XIDCache != nil {
+			if _, ok := h.XIDCache.Get(id.TelegramXId); ok {
+				xidCacheHits.Inc()
+				continue
+			}
+			xidCacheMisses.Inc()
+		}
+
+		sealed, err := recipients.Encrypt([]byte(id.OpenTelegramID), []byte(id.TelegramXId))
 		if err != nil {
 			log.Printf("[hook] ❌ failed to encrypt telegram_id %s: %v", id.OpenTelegramID, err)
 			continue
 		}
 
+		// The top-level fields keep single-key casters working; they
+		// repeat the first recipient.
+		first := sealed[0]
 		msg := &hookpb.EncryptedTelegramID{
-			TelegramXid: id.TelegramXId,
-			EncryptedId: encryptedID,
+			TelegramXid:        id.TelegramXId,
+			EncryptedId:        first.Ciphertext,
+			Version:            first.Version,
+			EphemeralPublicKey: first.EphemeralPublicKey,
+			KeyId:              first.KeyID,
+		}
+		for _, s := range sealed {
+			msg.Recipients = append(msg.Recipients, &hookpb.RecipientEncryptedID{
+				KeyId:              s.KeyID,
+				Version:            s.Version,
+				EncryptedId:        s.Ciphertext,
+				EphemeralPublicKey: s.EphemeralPublicKey,
+			})
 		}
 
 		data, err := proto.Marshal(msg)
@@ -111,15 +501,49 @@ func publishTelegramIDs(result FilterResult, h *OutboundHandler) {
 			continue
 		}
 
-		if err := h.Channel.Publish("murmapp", "telegram.encrypted.id", data); err != nil {
+		headers := messageHeaders(msg, MessageID(webhookID, result.UpdateID, id.TelegramXId), first.KeyID)
+		if h.Signer != nil {
+			h.Signer.Sign(headers, h.Exchange, "telegram.encrypted.id", data)
+		}
+		if err := h.Publisher.Publish(ctx, "telegram.encrypted.id", headers, data); err != nil {
 			log.Printf("[hook] ❌ failed to publish encrypted telegram_id to MQ: %v", err)
 			continue
 		}
+
+		if h.XIDCache != nil {
+			h.XIDCache.Add(id.TelegramXId, struct{}{})
+		}
 	}
 }
 
-func ComputeWebhookID(secretToken, secretSalt string) string {
+// messageHeaders describes a published protobuf message so consumers can
+// route, dedupe and decrypt it without inspecting the body.
+func messageHeaders(msg proto.Message, messageID, keyID string) map[string]string {
+	return map[string]string{
+		broker.HeaderContentType: "application/x-protobuf",
+		broker.HeaderType:        string(proto.MessageName(msg)),
+		broker.HeaderMessageID:   messageID,
+		"x-schema-version":       schemaVersion,
+		"x-key-id":               keyID,
+	}
+}
+
+// MessageID derives a deterministic message ID from the webhook and Telegram
+// update_id, so redeliveries of one update share an ID. Extra parts tell apart
+// several messages produced from the same update. Updates without an
+// update_id get a random ID.
+func MessageID(webhookID string, updateID int64, parts ...string) string {
+	if updateID == 0 {
+		return rand.Text()
+	}
 	h := sha256.New()
-	h.Write([]byte(secretToken + secretSalt))
+	fmt.Fprintf(h, "%s:%d", webhookID, updateID)
+	for _, p := range parts {
+		h.Write([]byte(":" + p))
+	}
 	return hex.EncodeToString(h.Sum(nil))
 }
+
+func ComputeWebhookID(secretToken, secretSalt string) string {
+	return registry.WebhookID(secretToken, secretSalt)
+}
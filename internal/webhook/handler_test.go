@@ -3,23 +3,39 @@ package webhook_test
 import (
 	"bytes"
 	"context"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/eugene-ruby/xconnect/rabbitmq/mocks"
 	"github.com/eugene-ruby/xencryptor/xsecrets"
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/protobuf/proto"
+	"murmapp.hook/internal/broker"
+	"murmapp.hook/internal/cache"
+	"murmapp.hook/internal/compression"
 	"murmapp.hook/internal/config"
+	"murmapp.hook/internal/dedupe"
+	"murmapp.hook/internal/envelope"
+	"murmapp.hook/internal/hybrid"
+	"murmapp.hook/internal/ratelimit"
+	"murmapp.hook/internal/registry"
 	"murmapp.hook/internal/webhook"
+	"murmapp.hook/pkg/hooksig"
 	hookpb "murmapp.hook/proto"
 )
 
@@ -28,12 +44,13 @@ func TestHandleWebhook_success(t *testing.T) {
 	conf, err := config.LoadConfig()
 	require.NoError(t, err)
 
-	salt := string(conf.Encryption.SecretSalt)
+	salt := string(conf.Encryption.SecretSalt.Bytes())
 	openID := "456"
 	expectedXID := webhook.TelegramXID(openID, salt)
 
 	// Simulate incoming JSON with sensitive ID
 	payload := map[string]any{
+		"update_id": 1001,
 		"message": map[string]any{
 			"from": map[string]any{
 				"id": openID,
@@ -50,6 +67,7 @@ func TestHandleWebhook_success(t *testing.T) {
 
 	// Build HTTP request with context and webhook_id param
 	req := httptest.NewRequest("POST", "/hook", bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Telegram-Bot-Api-Secret-Token", token)
 	req.RemoteAddr = "1.2.3.4"
 
@@ -58,11 +76,11 @@ func TestHandleWebhook_success(t *testing.T) {
 	ctx := context.WithValue(req.Context(), chi.RouteCtxKey, rctx)
 	req = req.WithContext(ctx)
 
-	// Use mocked RabbitMQ channel
-	channel := mocks.NewMockChannel()
+	// Use in-memory publisher instead of a real broker
+	publisher := broker.NewMemoryPublisher()
 	handler := &webhook.OutboundHandler{
-		Config:  *conf,
-		Channel: channel,
+		Config:    *conf,
+		Publisher: publisher,
 	}
 
 	// Execute handler
@@ -70,18 +88,22 @@ func TestHandleWebhook_success(t *testing.T) {
 	webhook.HandleWebhook(rec, req, handler)
 
 	require.Equal(t, http.StatusOK, rec.Code)
-	require.Len(t, channel.PublishedMessages, 2)
+	require.Len(t, publisher.Messages(), 2)
 
 	var foundPayload, foundEncryptedID bool
 
 	// Inspect published messages
-	for _, msg := range channel.PublishedMessages {
-		switch msg.RoutingKey {
+	for _, msg := range publisher.Messages() {
+		switch msg.Topic {
 		case "telegram.messages.in":
 			var p hookpb.TelegramWebhookPayload
 			err := proto.Unmarshal(msg.Body, &p)
 			require.NoError(t, err)
 			require.Equal(t, webhookID, p.WebhookId)
+			require.Equal(t, "application/x-protobuf", msg.Headers[broker.HeaderContentType])
+			require.Equal(t, "hook.TelegramWebhookPayload", msg.Headers[broker.HeaderType])
+			require.Equal(t, webhook.MessageID(webhookID, 1001), msg.Headers[broker.HeaderMessageID])
+			require.Equal(t, conf.Encryption.PayloadKeyID, msg.Headers["x-key-id"])
 			foundPayload = true
 
 		case "telegram.encrypted.id":
@@ -89,6 +111,8 @@ func TestHandleWebhook_success(t *testing.T) {
 			err := proto.Unmarshal(msg.Body, &enc)
 			require.NoError(t, err)
 			require.Equal(t, expectedXID, enc.TelegramXid)
+			require.Equal(t, "hook.EncryptedTelegramID", msg.Headers[broker.HeaderType])
+			require.Equal(t, webhook.MessageID(webhookID, 1001, expectedXID), msg.Headers[broker.HeaderMessageID])
 
 			// Decrypt and verify original ID
 			decryptedID, err := xsecrets.RSADecryptBytes(enc.EncryptedId, privateKey(t))
@@ -102,6 +126,207 @@ func TestHandleWebhook_success(t *testing.T) {
 	require.True(t, foundEncryptedID, "expected EncryptedTelegramID to be published")
 }
 
+func TestHandleWebhook_envelopeEncryption(t *testing.T) {
+	conf, err := config.LoadConfig()
+	require.NoError(t, err)
+	conf.Encryption.PayloadAAD = true
+
+	ring, err := envelope.NewKeyring("kek-1", []envelope.Key{{ID: "kek-1", Material: conf.Encryption.PayloadEncryptionKey.Bytes()}})
+	require.NoError(t, err)
+	sealer, err := envelope.NewSealer(ring, envelope.AlgorithmEnvelope, 0)
+	require.NoError(t, err)
+	publisher := broker.NewMemoryPublisher()
+	handler := &webhook.OutboundHandler{
+		Config:    *conf,
+		Publisher: publisher,
+		Sealer:    sealer,
+	}
+
+	raw := []byte(`{"update_id": 1, "message": {"from": {"id": 7}, "text": "hi"}}`)
+	rec := httptest.NewRecorder()
+	webhook.HandleWebhook(rec, newWebhookRequest(t, conf, "abc", raw), handler)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	msg := publisher.Messages()[0]
+	require.Equal(t, "kek-1", msg.Headers["x-key-id"])
+
+	var p hookpb.TelegramWebhookPayload
+	require.NoError(t, proto.Unmarshal(msg.Body, &p))
+	require.Equal(t, "kek-1", p.KeyId)
+	require.Equal(t, envelope.AlgorithmEnvelope, p.Algorithm)
+	require.Equal(t, int64(1), p.UpdateId)
+	require.Equal(t, uint32(1), p.AadVersion)
+
+	sealed := envelope.Envelope{
+		Ciphertext: p.EncryptedPayload,
+		WrappedKey: p.EncryptedDataKey,
+		KeyID:      p.KeyId,
+		Algorithm:  p.Algorithm,
+	}
+	keks := map[string][]byte{"kek-1": conf.Encryption.PayloadEncryptionKey.Bytes()}
+	aad, err := webhook.PayloadAssociatedData(&p)
+	require.NoError(t, err)
+	plain, err := envelope.Open(sealed, keks, aad)
+	require.NoError(t, err)
+	require.Contains(t, string(plain), `"text":"hi"`)
+
+	// Replayed inside another bot's message, the ciphertext no longer opens.
+	p.WebhookId = "another-webhook"
+	aad, err = webhook.PayloadAssociatedData(&p)
+	require.NoError(t, err)
+	_, err = envelope.Open(sealed, keks, aad)
+	require.Error(t, err)
+}
+
+func TestHandleWebhook_withoutAssociatedData(t *testing.T) {
+	conf, err := config.LoadConfig()
+	require.NoError(t, err)
+	require.False(t, conf.Encryption.PayloadAAD, "associated data is opt-in")
+
+	publisher := broker.NewMemoryPublisher()
+	handler := &webhook.OutboundHandler{Config: *conf, Publisher: publisher}
+
+	raw := []byte(`{"update_id": 1, "message": {"from": {"id": 7}, "text": "hi"}}`)
+	rec := httptest.NewRecorder()
+	webhook.HandleWebhook(rec, newWebhookRequest(t, conf, "abc", raw), handler)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var p hookpb.TelegramWebhookPayload
+	require.NoError(t, proto.Unmarshal(publisher.Messages()[0].Body, &p))
+	require.Zero(t, p.AadVersion)
+
+	// Consumers that predate aad_version open it without associated data.
+	sealed := envelope.Envelope{Ciphertext: p.EncryptedPayload, KeyID: p.KeyId, Algorithm: p.Algorithm}
+	plain, err := envelope.Open(sealed, map[string][]byte{p.KeyId: conf.Encryption.PayloadEncryptionKey.Bytes()}, nil)
+	require.NoError(t, err)
+	require.Contains(t, string(plain), `"text":"hi"`)
+}
+
+func TestHandleWebhook_compression(t *testing.T) {
+	conf, err := config.LoadConfig()
+	require.NoError(t, err)
+
+	compressor, err := compression.New(compression.Zstd, 64)
+	require.NoError(t, err)
+	publisher := broker.NewMemoryPublisher()
+	handler := &webhook.OutboundHandler{
+		Config:     *conf,
+		Publisher:  publisher,
+		Compressor: compressor,
+	}
+
+	text := strings.Repeat("compressible ", 50)
+	raw := []byte(`{"update_id": 1, "message": {"from": {"id": 7}, "text": "` + text + `"}}`)
+	rec := httptest.NewRecorder()
+	webhook.HandleWebhook(rec, newWebhookRequest(t, conf, "abc", raw), handler)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var p hookpb.TelegramWebhookPayload
+	require.NoError(t, proto.Unmarshal(publisher.Messages()[0].Body, &p))
+	require.Equal(t, compression.Zstd, p.Compression)
+
+	aad, err := webhook.PayloadAssociatedData(&p)
+	require.NoError(t, err)
+	sealed := envelope.Envelope{Ciphertext: p.EncryptedPayload, KeyID: p.KeyId, Algorithm: p.Algorithm}
+	compressed, err := envelope.Open(sealed, map[string][]byte{p.KeyId: conf.Encryption.PayloadEncryptionKey.Bytes()}, aad)
+	require.NoError(t, err)
+	plain, err := compression.Decompress(compressed, p.Compression, 1<<20)
+	require.NoError(t, err)
+	require.Contains(t, string(plain), text)
+}
+
+func TestHandleWebhook_x25519TelegramIDs(t *testing.T) {
+	conf, err := config.LoadConfig()
+	require.NoError(t, err)
+
+	caster, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	audit, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	recipients, err := hybrid.NewRecipients(
+		hybrid.Recipient{KeyID: "caster-x", Encrypter: hybrid.NewX25519Encrypter(caster.PublicKey(), "caster-x", time.Minute)},
+		hybrid.Recipient{KeyID: "audit-x", Encrypter: hybrid.NewX25519Encrypter(audit.PublicKey(), "audit-x", time.Minute)},
+	)
+	require.NoError(t, err)
+	publisher := broker.NewMemoryPublisher()
+	handler := &webhook.OutboundHandler{
+		Config:     *conf,
+		Publisher:  publisher,
+		Recipients: recipients,
+	}
+
+	raw := []byte(`{"update_id": 1, "message": {"from": {"id": 123}, "chat": {"id": 456}}}`)
+	rec := httptest.NewRecorder()
+	webhook.HandleWebhook(rec, newWebhookRequest(t, conf, "abc", raw), handler)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var ids, auditIDs []string
+	for _, msg := range publisher.Messages() {
+		if msg.Topic != "telegram.encrypted.id" {
+			continue
+		}
+		require.Equal(t, "caster-x", msg.Headers["x-key-id"])
+
+		var enc hookpb.EncryptedTelegramID
+		require.NoError(t, proto.Unmarshal(msg.Body, &enc))
+		require.Equal(t, hybrid.VersionX25519, enc.Version)
+		require.Equal(t, "caster-x", enc.KeyId)
+
+		id, err := hybrid.OpenX25519(caster, hybrid.Sealed{
+			Version:            enc.Version,
+			Ciphertext:         enc.EncryptedId,
+			EphemeralPublicKey: enc.EphemeralPublicKey,
+		}, []byte(enc.TelegramXid))
+		require.NoError(t, err)
+		ids = append(ids, string(id))
+
+		require.Len(t, enc.Recipients, 2)
+		require.Equal(t, "caster-x", enc.Recipients[0].KeyId)
+		require.Equal(t, enc.EncryptedId, enc.Recipients[0].EncryptedId)
+		require.Equal(t, "audit-x", enc.Recipients[1].KeyId)
+		id, err = hybrid.OpenX25519(audit, hybrid.Sealed{
+			Version:            enc.Recipients[1].Version,
+			Ciphertext:         enc.Recipients[1].EncryptedId,
+			EphemeralPublicKey: enc.Recipients[1].EphemeralPublicKey,
+		}, []byte(enc.TelegramXid))
+		require.NoError(t, err)
+		auditIDs = append(auditIDs, string(id))
+	}
+	require.ElementsMatch(t, []string{"123", "456"}, ids)
+	require.ElementsMatch(t, []string{"123", "456"}, auditIDs)
+}
+
+func TestHandleWebhook_signsMessages(t *testing.T) {
+	conf, err := config.LoadConfig()
+	require.NoError(t, err)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	publisher := broker.NewMemoryPublisher()
+	handler := &webhook.OutboundHandler{
+		Config:    *conf,
+		Publisher: publisher,
+		Signer:    hooksig.NewSigner(priv),
+		Exchange:  "murmapp",
+	}
+
+	raw := []byte(`{"update_id": 1, "message": {"from": {"id": 123}}}`)
+	rec := httptest.NewRecorder()
+	webhook.HandleWebhook(rec, newWebhookRequest(t, conf, "abc", raw), handler)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	v := hooksig.NewVerifier(map[string]ed25519.PublicKey{hooksig.KeyID(pub): pub}, time.Minute)
+	msgs := publisher.Messages()
+	require.Len(t, msgs, 2)
+	for _, msg := range msgs {
+		require.NoError(t, v.Verify(msg.Headers, "murmapp", msg.Topic, msg.Body), msg.Topic)
+	}
+	require.ErrorIs(t, v.Verify(msgs[0].Headers, "murmapp", msgs[1].Topic, msgs[0].Body), hooksig.ErrInvalidSignature,
+		"a payload replayed under another routing key does not verify")
+	require.ErrorIs(t, v.Verify(msgs[0].Headers, "tenant", msgs[0].Topic, msgs[0].Body), hooksig.ErrInvalidSignature,
+		"a payload replayed onto another exchange does not verify")
+}
+
 func TestHandleWebhook_invalidToken(t *testing.T) {
 	conf, err := config.LoadConfig()
 	require.NoError(t, err)
@@ -109,40 +334,42 @@ func TestHandleWebhook_invalidToken(t *testing.T) {
 	raw := []byte(`{"message": {"from": {"id": "123"}}}`)
 
 	req := httptest.NewRequest("POST", "/hook", bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Telegram-Bot-Api-Secret-Token", "wrong-token")
 
 	rctx := chi.NewRouteContext()
 	rctx.URLParams.Add("webhook_id", "invalid-id")
 	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
 
-	channel := mocks.NewMockChannel()
-	handler := &webhook.OutboundHandler{Config: *conf, Channel: channel}
+	publisher := broker.NewMemoryPublisher()
+	handler := &webhook.OutboundHandler{Config: *conf, Publisher: publisher}
 
 	rec := httptest.NewRecorder()
 	webhook.HandleWebhook(rec, req, handler)
 
 	require.Equal(t, http.StatusForbidden, rec.Code)
-	require.Len(t, channel.PublishedMessages, 0)
+	require.Len(t, publisher.Messages(), 0)
 }
 
 func TestHandleWebhook_invalidJSON(t *testing.T) {
 	conf, _ := config.LoadConfig()
 
 	req := httptest.NewRequest("POST", "/hook", bytes.NewReader([]byte(`{ not json }`)))
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Telegram-Bot-Api-Secret-Token", "abc")
 	rctx := chi.NewRouteContext()
-	webhookID := webhook.ComputeWebhookID("abc", string(conf.Encryption.SecretSalt))
+	webhookID := webhook.ComputeWebhookID("abc", string(conf.Encryption.SecretSalt.Bytes()))
 	rctx.URLParams.Add("webhook_id", webhookID)
 	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
 
-	channel := mocks.NewMockChannel()
-	handler := &webhook.OutboundHandler{Config: *conf, Channel: channel}
+	publisher := broker.NewMemoryPublisher()
+	handler := &webhook.OutboundHandler{Config: *conf, Publisher: publisher}
 
 	rec := httptest.NewRecorder()
 	webhook.HandleWebhook(rec, req, handler)
 
 	require.Equal(t, http.StatusOK, rec.Code)
-	require.Len(t, channel.PublishedMessages, 0)
+	require.Len(t, publisher.Messages(), 0)
 }
 
 func TestHandleWebhook_payloadNoMatches(t *testing.T) {
@@ -150,24 +377,404 @@ func TestHandleWebhook_payloadNoMatches(t *testing.T) {
 
 	raw := []byte(`{"message": {"text": "nothing to redact"}}`)
 	token := "abc"
-	webhookID := webhook.ComputeWebhookID(token, string(conf.Encryption.SecretSalt))
+	webhookID := webhook.ComputeWebhookID(token, string(conf.Encryption.SecretSalt.Bytes()))
 
 	req := httptest.NewRequest("POST", "/hook", bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Telegram-Bot-Api-Secret-Token", token)
 	rctx := chi.NewRouteContext()
 	rctx.URLParams.Add("webhook_id", webhookID)
 	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
 
 	handler := &webhook.OutboundHandler{
-		Config:  *conf,
-		Channel: mocks.NewMockChannel(),
+		Config:    *conf,
+		Publisher: broker.NewMemoryPublisher(),
 	}
 
 	rec := httptest.NewRecorder()
 	webhook.HandleWebhook(rec, req, handler)
 
 	require.Equal(t, http.StatusOK, rec.Code)
-	require.Len(t, handler.Channel.(*mocks.MockChannel).PublishedMessages, 0)
+	require.Len(t, handler.Publisher.(*broker.MemoryPublisher).Messages(), 0)
+}
+
+func TestHandleWebhook_publishFailure(t *testing.T) {
+	conf, err := config.LoadConfig()
+	require.NoError(t, err)
+
+	raw := []byte(`{"message": {"from": {"id": "123"}}}`)
+	token := "abc"
+	webhookID := webhook.ComputeWebhookID(token, string(conf.Encryption.SecretSalt.Bytes()))
+
+	req := httptest.NewRequest("POST", "/hook", bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Telegram-Bot-Api-Secret-Token", token)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("webhook_id", webhookID)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+	publisher := broker.NewMemoryPublisher()
+	publisher.FailWith(errors.New("broker unavailable"))
+	handler := &webhook.OutboundHandler{Config: *conf, Publisher: publisher}
+
+	rec := httptest.NewRecorder()
+	webhook.HandleWebhook(rec, req, handler)
+
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+}
+
+func TestHandleWebhook_duplicateUpdate(t *testing.T) {
+	conf, err := config.LoadConfig()
+	require.NoError(t, err)
+
+	raw := []byte(`{"update_id": 555, "message": {"from": {"id": "123"}}}`)
+	publisher := broker.NewMemoryPublisher()
+	handler := &webhook.OutboundHandler{
+		Config:    *conf,
+		Publisher: publisher,
+		Dedupe:    dedupe.NewMemoryStore(100, time.Hour),
+	}
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		webhook.HandleWebhook(rec, newWebhookRequest(t, conf, "dup-token", raw), handler)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	// One payload and one encrypted ID, published only once.
+	require.Len(t, publisher.Messages(), 2)
+}
+
+func TestHandleWebhook_duplicateAfterPublishFailure(t *testing.T) {
+	conf, err := config.LoadConfig()
+	require.NoError(t, err)
+
+	raw := []byte(`{"update_id": 777, "message": {"from": {"id": "123"}}}`)
+	publisher := broker.NewMemoryPublisher()
+	handler := &webhook.OutboundHandler{
+		Config:    *conf,
+		Publisher: publisher,
+		Dedupe:    dedupe.NewMemoryStore(100, time.Hour),
+	}
+
+	publisher.FailWith(errors.New("broker unavailable"))
+	rec := httptest.NewRecorder()
+	webhook.HandleWebhook(rec, newWebhookRequest(t, conf, "retry-token", raw), handler)
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	// Telegram's retry must be published, not suppressed as a duplicate.
+	publisher.FailWith(nil)
+	rec = httptest.NewRecorder()
+	webhook.HandleWebhook(rec, newWebhookRequest(t, conf, "retry-token", raw), handler)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, publisher.Messages(), 2)
+}
+
+func TestHandleWebhook_xidCache(t *testing.T) {
+	conf, err := config.LoadConfig()
+	require.NoError(t, err)
+
+	publisher := broker.NewMemoryPublisher()
+	handler := &webhook.OutboundHandler{
+		Config:    *conf,
+		Publisher: publisher,
+		XIDCache:  cache.NewLRU[string, struct{}](100, time.Hour),
+	}
+
+	send := func(updateID int) {
+		raw := []byte(fmt.Sprintf(`{"update_id": %d, "message": {"from": {"id": "123"}}}`, updateID))
+		rec := httptest.NewRecorder()
+		webhook.HandleWebhook(rec, newWebhookRequest(t, conf, "xid-token", raw), handler)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	countIDs := func() int {
+		n := 0
+		for _, msg := range publisher.Messages() {
+			if msg.Topic == "telegram.encrypted.id" {
+				n++
+			}
+		}
+		return n
+	}
+
+	send(1)
+	send(2)
+	require.Equal(t, 1, countIDs(), "second update must reuse the cached XID")
+
+	handler.ResetXIDCache()
+	send(3)
+	require.Equal(t, 2, countIDs(), "reset must force a re-emit")
+}
+
+func TestHandleWebhook_async(t *testing.T) {
+	conf, err := config.LoadConfig()
+	require.NoError(t, err)
+
+	publisher := broker.NewMemoryPublisher()
+	handler := &webhook.OutboundHandler{Config: *conf, Publisher: publisher}
+	handler.Pipeline = webhook.NewPipeline(handler.Process, 10, 1, time.Second)
+	handler.Pipeline.Start()
+
+	raw := []byte(`{"update_id": 9, "message": {"from": {"id": "123"}}}`)
+	rec := httptest.NewRecorder()
+	webhook.HandleWebhook(rec, newWebhookRequest(t, conf, "async-token", raw), handler)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	handler.Pipeline.Shutdown()
+	require.Len(t, publisher.Messages(), 2)
+
+	// After shutdown the handler refuses new updates so Telegram retries elsewhere.
+	rec = httptest.NewRecorder()
+	webhook.HandleWebhook(rec, newWebhookRequest(t, conf, "async-token", raw), handler)
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestHandleWebhook_rejectsWrongMethod(t *testing.T) {
+	conf, err := config.LoadConfig()
+	require.NoError(t, err)
+
+	req := newWebhookRequest(t, conf, "abc", []byte(`{}`))
+	req.Method = http.MethodGet
+	publisher := broker.NewMemoryPublisher()
+
+	rec := httptest.NewRecorder()
+	webhook.HandleWebhook(rec, req, &webhook.OutboundHandler{Config: *conf, Publisher: publisher})
+
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	require.Equal(t, http.MethodPost, rec.Header().Get("Allow"))
+	require.Len(t, publisher.Messages(), 0)
+}
+
+func TestHandleWebhook_rejectsNonJSONContentType(t *testing.T) {
+	conf, err := config.LoadConfig()
+	require.NoError(t, err)
+
+	req := newWebhookRequest(t, conf, "abc", []byte(`{"message": {"from": {"id": "1"}}}`))
+	req.Header.Set("Content-Type", "text/plain")
+	publisher := broker.NewMemoryPublisher()
+
+	rec := httptest.NewRecorder()
+	webhook.HandleWebhook(rec, req, &webhook.OutboundHandler{Config: *conf, Publisher: publisher})
+
+	require.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+	require.Len(t, publisher.Messages(), 0)
+}
+
+func TestHandleWebhook_acceptsJSONWithCharset(t *testing.T) {
+	conf, err := config.LoadConfig()
+	require.NoError(t, err)
+
+	req := newWebhookRequest(t, conf, "abc", []byte(`{"message": {"from": {"id": "1"}}}`))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	rec := httptest.NewRecorder()
+	webhook.HandleWebhook(rec, req, &webhook.OutboundHandler{Config: *conf, Publisher: broker.NewMemoryPublisher()})
+
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestHandleWebhook_rejectsOversizedBody(t *testing.T) {
+	conf, err := config.LoadConfig()
+	require.NoError(t, err)
+	conf.MaxBodyBytes = 64
+
+	raw := []byte(`{"message": {"from": {"id": "1"}, "text": "` + strings.Repeat("x", 100) + `"}}`)
+	publisher := broker.NewMemoryPublisher()
+
+	rec := httptest.NewRecorder()
+	webhook.HandleWebhook(rec, newWebhookRequest(t, conf, "abc", raw), &webhook.OutboundHandler{Config: *conf, Publisher: publisher})
+
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	require.Len(t, publisher.Messages(), 0)
+}
+
+func TestHandleWebhook_invalidTokenDoesNotReadBody(t *testing.T) {
+	conf, err := config.LoadConfig()
+	require.NoError(t, err)
+
+	body := &countingReader{r: bytes.NewReader([]byte(`{"message": {"from": {"id": "1"}}}`))}
+	req := newWebhookRequest(t, conf, "abc", nil)
+	req.Body = io.NopCloser(body)
+	req.Header.Set("X-Telegram-Bot-Api-Secret-Token", "wrong-token")
+
+	rec := httptest.NewRecorder()
+	webhook.HandleWebhook(rec, req, &webhook.OutboundHandler{Config: *conf, Publisher: broker.NewMemoryPublisher()})
+
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Zero(t, body.n, "body must not be read before authentication")
+}
+
+func TestHandleWebhook_locksOutRepeatedFailures(t *testing.T) {
+	conf, err := config.LoadConfig()
+	require.NoError(t, err)
+
+	handler := &webhook.OutboundHandler{
+		Config:    *conf,
+		Publisher: broker.NewMemoryPublisher(),
+		AuthGuard: webhook.NewAuthGuard(2, time.Minute, time.Hour),
+	}
+	raw := []byte(`{"update_id": 1, "message": {"text": "hi"}}`)
+
+	for i := 0; i < 2; i++ {
+		req := newWebhookRequest(t, conf, "abc", raw)
+		req.Header.Set("X-Telegram-Bot-Api-Secret-Token", "wrong-token")
+		req.RemoteAddr = "5.6.7.8:1234"
+		rec := httptest.NewRecorder()
+		webhook.HandleWebhook(rec, req, handler)
+		require.Equal(t, http.StatusForbidden, rec.Code)
+	}
+
+	// Even a valid token is refused while the source is locked out.
+	req := newWebhookRequest(t, conf, "abc", raw)
+	req.RemoteAddr = "5.6.7.8:4321"
+	rec := httptest.NewRecorder()
+	webhook.HandleWebhook(rec, req, handler)
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	req = newWebhookRequest(t, conf, "abc", raw)
+	req.RemoteAddr = "9.9.9.9:1234"
+	rec = httptest.NewRecorder()
+	webhook.HandleWebhook(rec, req, handler)
+	require.Equal(t, http.StatusOK, rec.Code, "other sources are unaffected")
+}
+
+func TestHandleWebhook_rateLimit(t *testing.T) {
+	conf, err := config.LoadConfig()
+	require.NoError(t, err)
+
+	publisher := broker.NewMemoryPublisher()
+	handler := &webhook.OutboundHandler{
+		Config:      *conf,
+		Publisher:   publisher,
+		RateLimiter: ratelimit.NewLimiter(ratelimit.Limit{}, ratelimit.Limit{Rate: 0.01, Burst: 2}),
+		ShedTypes:   map[string]bool{"message_reaction": true},
+	}
+
+	send := func(raw string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		webhook.HandleWebhook(rec, newWebhookRequest(t, conf, "abc", []byte(raw)), handler)
+		return rec
+	}
+
+	// The reaction would use the last half of the bucket, so it is shed.
+	require.Equal(t, http.StatusOK, send(`{"update_id": 1, "message": {"from": {"id": 7}, "text": "hi"}}`).Code)
+	require.Equal(t, http.StatusOK, send(`{"update_id": 2, "message_reaction": {}}`).Code)
+	require.Equal(t, http.StatusOK, send(`{"update_id": 3, "message": {"from": {"id": 7}, "text": "hi"}}`).Code)
+
+	rec := send(`{"update_id": 4, "message": {"text": "hi"}}`)
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	var published int
+	for _, m := range publisher.Messages() {
+		if m.Topic == "telegram.messages.in" {
+			published++
+		}
+	}
+	require.Equal(t, 2, published, "only the two regular messages are published")
+}
+
+func TestHandleWebhook_unknownWebhook(t *testing.T) {
+	conf, err := config.LoadConfig()
+	require.NoError(t, err)
+
+	reg := registry.New(registry.NewMemoryStore(), string(conf.Encryption.SecretSalt.Bytes()), 10, time.Minute)
+	registered, token, err := reg.Register(context.Background(), "bot-1", nil, nil)
+	require.NoError(t, err)
+
+	handler := &webhook.OutboundHandler{
+		Config:    *conf,
+		Publisher: broker.NewMemoryPublisher(),
+		Registry:  reg,
+		AuthGuard: webhook.NewAuthGuard(1, time.Minute, time.Hour),
+	}
+	raw := []byte(`{"update_id": 1, "message": {"from": {"id": 7}, "text": "hi"}}`)
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		webhook.HandleWebhook(rec, newWebhookRequest(t, conf, "not-registered", raw), handler)
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	webhook.HandleWebhook(rec, newWebhookRequest(t, conf, token, raw), handler)
+	require.Equal(t, http.StatusOK, rec.Code, "unknown webhook IDs do not count towards the lockout")
+
+	// Re-registration installs a new token for the same webhook ID.
+	_, newToken, err := reg.Register(context.Background(), "bot-1", nil, func(context.Context, registry.Registration, string) error { return nil })
+	require.NoError(t, err)
+
+	req := newWebhookRequest(t, conf, newToken, raw)
+	rctx := chi.RouteContext(req.Context())
+	rctx.URLParams.Values[0] = registered.WebhookID
+
+	rec = httptest.NewRecorder()
+	webhook.HandleWebhook(rec, req, handler)
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestHandleWebhook_overrides(t *testing.T) {
+	conf, err := config.LoadConfig()
+	require.NoError(t, err)
+
+	webhookID := webhook.ComputeWebhookID("abc", string(conf.Encryption.SecretSalt.Bytes()))
+	publisher := broker.NewMemoryPublisher()
+	handler := &webhook.OutboundHandler{
+		Config:    *conf,
+		Publisher: publisher,
+		Overrides: map[string]registry.Overrides{
+			webhookID: {
+				Exchange:       "tenant",
+				RoutingKey:     "tenant.messages.in",
+				AllowedUpdates: []string{"message"},
+				Unmatched:      registry.UnmatchedForward,
+			},
+		},
+	}
+
+	send := func(raw string) int {
+		rec := httptest.NewRecorder()
+		webhook.HandleWebhook(rec, newWebhookRequest(t, conf, "abc", []byte(raw)), handler)
+		return rec.Code
+	}
+
+	// Not an allowed update type: acknowledged and dropped.
+	require.Equal(t, http.StatusOK, send(`{"update_id": 1, "edited_message": {"from": {"id": 7}}}`))
+	require.Empty(t, publisher.Messages())
+
+	// No privacy rule matches, but the bot forwards unmatched updates.
+	require.Equal(t, http.StatusOK, send(`{"update_id": 2, "message": {"text": "hi"}}`))
+
+	msgs := publisher.Messages()
+	require.Len(t, msgs, 1)
+	require.Equal(t, "tenant", msgs[0].Exchange)
+	require.Equal(t, "tenant.messages.in", msgs[0].Topic)
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+// newWebhookRequest builds an authorized webhook request for token.
+func newWebhookRequest(t *testing.T, conf *config.Config, token string, raw []byte) *http.Request {
+	t.Helper()
+
+	webhookID := webhook.ComputeWebhookID(token, string(conf.Encryption.SecretSalt.Bytes()))
+	req := httptest.NewRequest("POST", "/hook", bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Telegram-Bot-Api-Secret-Token", token)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("webhook_id", webhookID)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
 }
 
 // privateKey loads the RSA private key from an environment variable
@@ -0,0 +1,158 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"murmapp.hook/internal/metrics"
+)
+
+var (
+	// ErrQueueFull is returned by Enqueue when all queue slots are taken.
+	ErrQueueFull = errors.New("ingest queue is full")
+	// ErrPipelineClosed is returned by Enqueue once shutdown has started.
+	ErrPipelineClosed = errors.New("ingest pipeline is shutting down")
+)
+
+// processTimeout bounds a single filter+encrypt+publish run in a worker.
+const processTimeout = 30 * time.Second
+
+var (
+	queueDepth atomic.Int64
+
+	_ = metrics.NewGaugeFunc(
+		"hook_ingest_queue_depth",
+		"Updates accepted over HTTP and waiting for a worker.",
+		func() float64 { return float64(queueDepth.Load()) },
+	)
+	ingestRejected = metrics.NewCounter(
+		"hook_ingest_rejected_total",
+		"Updates refused with 429 because the ingest queue was full.",
+	)
+	ingestFailed = metrics.NewCounter(
+		"hook_ingest_failed_total",
+		"Queued updates that could not be published after being acknowledged to Telegram.",
+	)
+	ingestDropped = metrics.NewCounter(
+		"hook_ingest_dropped_total",
+		"Queued updates discarded at shutdown because the drain timeout expired.",
+	)
+)
+
+// Pipeline is a bounded in-process queue drained by a fixed pool of workers.
+// Updates are acknowledged to Telegram as soon as they are queued, so a
+// broker outage no longer stalls Telegram's connections; the trade-off is that
+// a queued update is lost if publishing it fails or the process dies.
+type Pipeline struct {
+	process      func(context.Context, Update) error
+	jobs         chan Update
+	workers      int
+	drainTimeout time.Duration
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+
+	// ctx is cancelled when the drain timeout expires, aborting in-flight
+	// publishes and making workers discard the rest of the queue.
+	ctx   context.Context
+	abort context.CancelFunc
+}
+
+// NewPipeline creates a pipeline with queueSize slots and the given number of workers.
+// On shutdown queued updates are still processed for up to drainTimeout;
+// a zero drainTimeout drops them immediately.
+func NewPipeline(process func(context.Context, Update) error, queueSize, workers int, drainTimeout time.Duration) *Pipeline {
+	ctx, abort := context.WithCancel(context.Background())
+	return &Pipeline{
+		process:      process,
+		jobs:         make(chan Update, queueSize),
+		workers:      workers,
+		drainTimeout: drainTimeout,
+		ctx:          ctx,
+		abort:        abort,
+	}
+}
+
+// Start launches the workers.
+func (p *Pipeline) Start() {
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+}
+
+// Enqueue hands u to the workers without blocking.
+func (p *Pipeline) Enqueue(u Update) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrPipelineClosed
+	}
+	select {
+	case p.jobs <- u:
+		queueDepth.Add(1)
+		return nil
+	default:
+		ingestRejected.Inc()
+		return ErrQueueFull
+	}
+}
+
+// Shutdown stops accepting updates and waits for the workers to drain the
+// queue, abandoning whatever is left after the drain timeout.
+func (p *Pipeline) Shutdown() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.jobs)
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	if p.drainTimeout > 0 {
+		log.Printf("[hook] ⏳ draining %d queued update(s)...", len(p.jobs))
+		select {
+		case <-done:
+			p.abort()
+			return
+		case <-time.After(p.drainTimeout):
+		}
+	}
+
+	p.abort()
+	<-done
+}
+
+func (p *Pipeline) work() {
+	defer p.wg.Done()
+
+	for u := range p.jobs {
+		queueDepth.Add(-1)
+
+		if p.ctx.Err() != nil {
+			ingestDropped.Inc()
+			log.Printf("[hook] 🗑️ dropped queued update from %s at shutdown", u.RemoteIP)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(p.ctx, processTimeout)
+		if err := p.process(ctx, u); err != nil {
+			ingestFailed.Inc()
+			log.Printf("[hook] ❌ queued update from %s was not published: %v", u.RemoteIP, err)
+		}
+		cancel()
+	}
+}
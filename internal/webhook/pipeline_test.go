@@ -0,0 +1,68 @@
+package webhook
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeline_queueFull(t *testing.T) {
+	block := make(chan struct{})
+	p := NewPipeline(func(ctx context.Context, u Update) error {
+		<-block
+		return nil
+	}, 1, 1, time.Second)
+	p.Start()
+
+	// One update is taken by the worker, one waits in the queue.
+	require.NoError(t, p.Enqueue(Update{}))
+	waitFor(t, func() bool { return len(p.jobs) == 0 })
+	require.NoError(t, p.Enqueue(Update{}))
+
+	require.ErrorIs(t, p.Enqueue(Update{}), ErrQueueFull)
+
+	close(block)
+	p.Shutdown()
+}
+
+func TestPipeline_drainsOnShutdown(t *testing.T) {
+	var processed atomic.Int32
+	p := NewPipeline(func(ctx context.Context, u Update) error {
+		processed.Add(1)
+		return nil
+	}, 10, 2, time.Second)
+	p.Start()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, p.Enqueue(Update{}))
+	}
+	p.Shutdown()
+
+	require.EqualValues(t, 5, processed.Load())
+	require.ErrorIs(t, p.Enqueue(Update{}), ErrPipelineClosed, "enqueue after shutdown")
+}
+
+func TestPipeline_dropsAfterDrainTimeout(t *testing.T) {
+	var processed atomic.Int32
+	p := NewPipeline(func(ctx context.Context, u Update) error {
+		processed.Add(1)
+		<-ctx.Done()
+		return ctx.Err()
+	}, 10, 1, 50*time.Millisecond)
+	p.Start()
+
+	for i := 0; i < 3; i++ {
+		_ = p.Enqueue(Update{})
+	}
+	p.Shutdown()
+
+	require.EqualValues(t, 1, processed.Load(), "only the in-flight update runs")
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	require.Eventually(t, cond, time.Second, time.Millisecond)
+}
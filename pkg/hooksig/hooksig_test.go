@@ -0,0 +1,102 @@
+package hooksig
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return pub, priv
+}
+
+func TestSignVerify(t *testing.T) {
+	pub, priv := testKey(t)
+	s := NewSigner(priv)
+	require.Equal(t, KeyID(pub), s.KeyID())
+
+	headers := map[string]string{"type": "hook.EncryptedTelegramID"}
+	s.Sign(headers, "murmapp", "telegram.encrypted.id", []byte("body"))
+	require.Equal(t, s.KeyID(), headers[HeaderKeyID])
+	require.NotEmpty(t, headers[HeaderSignature])
+	require.NotEmpty(t, headers[HeaderTimestamp])
+
+	v := NewVerifier(map[string]ed25519.PublicKey{KeyID(pub): pub}, time.Minute)
+	require.NoError(t, v.Verify(headers, "murmapp", "telegram.encrypted.id", []byte("body")))
+
+	require.ErrorIs(t, v.Verify(headers, "murmapp", "telegram.encrypted.id", []byte("other")), ErrInvalidSignature)
+	require.ErrorIs(t, v.Verify(headers, "murmapp", "telegram.messages.in", []byte("body")), ErrInvalidSignature,
+		"the routing key is signed")
+	require.ErrorIs(t, v.Verify(headers, "tenant", "telegram.encrypted.id", []byte("body")), ErrInvalidSignature,
+		"the exchange is signed")
+
+	moved := map[string]string{}
+	for k, val := range headers {
+		moved[k] = val
+	}
+	moved[HeaderTimestamp] = "1"
+	require.ErrorIs(t, v.Verify(moved, "murmapp", "telegram.encrypted.id", []byte("body")), ErrInvalidSignature,
+		"the timestamp is signed")
+}
+
+func TestVerify_rejects(t *testing.T) {
+	pub, priv := testKey(t)
+	otherPub, otherPriv := testKey(t)
+	v := NewVerifier(map[string]ed25519.PublicKey{KeyID(pub): pub}, 0)
+
+	require.ErrorIs(t, v.Verify(map[string]string{}, "murmapp", "rk", nil), ErrMissingSignature)
+
+	headers := map[string]string{}
+	NewSigner(otherPriv).Sign(headers, "murmapp", "rk", nil)
+	require.ErrorIs(t, v.Verify(headers, "murmapp", "rk", nil), ErrUnknownKey)
+
+	// A trusted key ID on a signature made by another key.
+	headers[HeaderKeyID] = KeyID(pub)
+	require.ErrorIs(t, v.Verify(headers, "murmapp", "rk", nil), ErrInvalidSignature)
+
+	headers = map[string]string{}
+	NewSigner(priv).Sign(headers, "murmapp", "rk", nil)
+	headers[HeaderSignature] = "not base64!"
+	require.ErrorIs(t, v.Verify(headers, "murmapp", "rk", nil), ErrInvalidSignature)
+
+	require.NotEqual(t, KeyID(pub), KeyID(otherPub))
+}
+
+func TestVerify_maxAge(t *testing.T) {
+	pub, priv := testKey(t)
+	now := time.Unix(1_700_000_000, 0)
+	s := NewSigner(priv)
+	s.now = func() time.Time { return now }
+	headers := map[string]string{}
+	s.Sign(headers, "murmapp", "rk", []byte("x"))
+
+	v := NewVerifier(map[string]ed25519.PublicKey{KeyID(pub): pub}, 5*time.Minute)
+	v.now = func() time.Time { return now.Add(4 * time.Minute) }
+	require.NoError(t, v.Verify(headers, "murmapp", "rk", []byte("x")))
+
+	v.now = func() time.Time { return now.Add(6 * time.Minute) }
+	err := v.Verify(headers, "murmapp", "rk", []byte("x"))
+	require.True(t, errors.Is(err, ErrExpired), err)
+
+	v.now = func() time.Time { return now.Add(-6 * time.Minute) }
+	require.ErrorIs(t, v.Verify(headers, "murmapp", "rk", []byte("x")), ErrExpired, "signed in the future")
+}
+
+func TestVerify_rotation(t *testing.T) {
+	oldPub, oldPriv := testKey(t)
+	newPub, newPriv := testKey(t)
+	v := NewVerifier(map[string]ed25519.PublicKey{KeyID(oldPub): oldPub, KeyID(newPub): newPub}, time.Minute)
+
+	for _, priv := range []ed25519.PrivateKey{oldPriv, newPriv} {
+		headers := map[string]string{}
+		NewSigner(priv).Sign(headers, "murmapp", "rk", []byte("x"))
+		require.NoError(t, v.Verify(headers, "murmapp", "rk", []byte("x")))
+	}
+}
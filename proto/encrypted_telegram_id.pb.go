@@ -22,9 +22,25 @@ const (
 )
 
 type EncryptedTelegramID struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	TelegramXid   string                 `protobuf:"bytes,1,opt,name=telegram_xid,json=telegramXid,proto3" json:"telegram_xid,omitempty"`
-	EncryptedId   []byte                 `protobuf:"bytes,2,opt,name=encrypted_id,json=encryptedId,proto3" json:"encrypted_id,omitempty"`
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	TelegramXid string                 `protobuf:"bytes,1,opt,name=telegram_xid,json=telegramXid,proto3" json:"telegram_xid,omitempty"`
+	// The decimal Telegram ID, encrypted as described by version, for the first
+	// configured recipient. Repeated in recipients[0].
+	EncryptedId []byte `protobuf:"bytes,2,opt,name=encrypted_id,json=encryptedId,proto3" json:"encrypted_id,omitempty"`
+	// 0 - RSA-OAEP-SHA256 under the caster's RSA key.
+	// 1 - X25519: shared = X25519(ephemeral, caster key);
+	//     key = HKDF-SHA256(shared, salt = ephemeral_public_key || caster public
+	//     key, info = "murmapp.hook EncryptedTelegramID v1", 32 bytes);
+	//     encrypted_id = 12-byte nonce || AES-256-GCM(key, id) with
+	//     telegram_xid as associated data.
+	Version uint32 `protobuf:"varint,3,opt,name=version,proto3" json:"version,omitempty"`
+	// Sender's X25519 public key (32 bytes); empty for version 0.
+	EphemeralPublicKey []byte `protobuf:"bytes,4,opt,name=ephemeral_public_key,json=ephemeralPublicKey,proto3" json:"ephemeral_public_key,omitempty"`
+	// Fingerprint of the caster key the ID was encrypted for.
+	KeyId string `protobuf:"bytes,5,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	// The ID encrypted for every configured recipient key, in configuration
+	// order. Each recipient picks the entry with its own key_id.
+	Recipients    []*RecipientEncryptedID `protobuf:"bytes,6,rep,name=recipients,proto3" json:"recipients,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -73,14 +89,123 @@ func (x *EncryptedTelegramID) GetEncryptedId() []byte {
 	return nil
 }
 
+func (x *EncryptedTelegramID) GetVersion() uint32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *EncryptedTelegramID) GetEphemeralPublicKey() []byte {
+	if x != nil {
+		return x.EphemeralPublicKey
+	}
+	return nil
+}
+
+func (x *EncryptedTelegramID) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *EncryptedTelegramID) GetRecipients() []*RecipientEncryptedID {
+	if x != nil {
+		return x.Recipients
+	}
+	return nil
+}
+
+// RecipientEncryptedID is the Telegram ID encrypted for one recipient key.
+// Fields mean the same as in EncryptedTelegramID.
+type RecipientEncryptedID struct {
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	KeyId              string                 `protobuf:"bytes,1,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	Version            uint32                 `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	EncryptedId        []byte                 `protobuf:"bytes,3,opt,name=encrypted_id,json=encryptedId,proto3" json:"encrypted_id,omitempty"`
+	EphemeralPublicKey []byte                 `protobuf:"bytes,4,opt,name=ephemeral_public_key,json=ephemeralPublicKey,proto3" json:"ephemeral_public_key,omitempty"`
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *RecipientEncryptedID) Reset() {
+	*x = RecipientEncryptedID{}
+	mi := &file_proto_encrypted_telegram_id_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecipientEncryptedID) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecipientEncryptedID) ProtoMessage() {}
+
+func (x *RecipientEncryptedID) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_encrypted_telegram_id_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecipientEncryptedID.ProtoReflect.Descriptor instead.
+func (*RecipientEncryptedID) Descriptor() ([]byte, []int) {
+	return file_proto_encrypted_telegram_id_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *RecipientEncryptedID) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *RecipientEncryptedID) GetVersion() uint32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *RecipientEncryptedID) GetEncryptedId() []byte {
+	if x != nil {
+		return x.EncryptedId
+	}
+	return nil
+}
+
+func (x *RecipientEncryptedID) GetEphemeralPublicKey() []byte {
+	if x != nil {
+		return x.EphemeralPublicKey
+	}
+	return nil
+}
+
 var File_proto_encrypted_telegram_id_proto protoreflect.FileDescriptor
 
 const file_proto_encrypted_telegram_id_proto_rawDesc = "" +
 	"\n" +
-	"!proto/encrypted_telegram_id.proto\x12\x04hook\"[\n" +
+	"!proto/encrypted_telegram_id.proto\x12\x04hook\"\xfa\x01\n" +
 	"\x13EncryptedTelegramID\x12!\n" +
 	"\ftelegram_xid\x18\x01 \x01(\tR\vtelegramXid\x12!\n" +
-	"\fencrypted_id\x18\x02 \x01(\fR\vencryptedIdB\x1bZ\x19murmapp.hook/proto;hookpbb\x06proto3"
+	"\fencrypted_id\x18\x02 \x01(\fR\vencryptedId\x12\x18\n" +
+	"\aversion\x18\x03 \x01(\rR\aversion\x120\n" +
+	"\x14ephemeral_public_key\x18\x04 \x01(\fR\x12ephemeralPublicKey\x12\x15\n" +
+	"\x06key_id\x18\x05 \x01(\tR\x05keyId\x12:\n" +
+	"\n" +
+	"recipients\x18\x06 \x03(\v2\x1a.hook.RecipientEncryptedIDR\n" +
+	"recipients\"\x9c\x01\n" +
+	"\x14RecipientEncryptedID\x12\x15\n" +
+	"\x06key_id\x18\x01 \x01(\tR\x05keyId\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\rR\aversion\x12!\n" +
+	"\fencrypted_id\x18\x03 \x01(\fR\vencryptedId\x120\n" +
+	"\x14ephemeral_public_key\x18\x04 \x01(\fR\x12ephemeralPublicKeyB\x1bZ\x19murmapp.hook/proto;hookpbb\x06proto3"
 
 var (
 	file_proto_encrypted_telegram_id_proto_rawDescOnce sync.Once
@@ -94,16 +219,18 @@ func file_proto_encrypted_telegram_id_proto_rawDescGZIP() []byte {
 	return file_proto_encrypted_telegram_id_proto_rawDescData
 }
 
-var file_proto_encrypted_telegram_id_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_proto_encrypted_telegram_id_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
 var file_proto_encrypted_telegram_id_proto_goTypes = []any{
-	(*EncryptedTelegramID)(nil), // 0: hook.EncryptedTelegramID
+	(*EncryptedTelegramID)(nil),  // 0: hook.EncryptedTelegramID
+	(*RecipientEncryptedID)(nil), // 1: hook.RecipientEncryptedID
 }
 var file_proto_encrypted_telegram_id_proto_depIdxs = []int32{
-	0, // [0:0] is the sub-list for method output_type
-	0, // [0:0] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	1, // 0: hook.EncryptedTelegramID.recipients:type_name -> hook.RecipientEncryptedID
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
 }
 
 func init() { file_proto_encrypted_telegram_id_proto_init() }
@@ -117,7 +244,7 @@ func file_proto_encrypted_telegram_id_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_encrypted_telegram_id_proto_rawDesc), len(file_proto_encrypted_telegram_id_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   1,
+			NumMessages:   2,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
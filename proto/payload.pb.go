@@ -22,12 +22,31 @@ const (
 )
 
 type TelegramWebhookPayload struct {
-	state            protoimpl.MessageState `protogen:"open.v1"`
-	WebhookId        string                 `protobuf:"bytes,1,opt,name=webhook_id,json=webhookId,proto3" json:"webhook_id,omitempty"`
-	EncryptedPayload []byte                 `protobuf:"bytes,2,opt,name=encrypted_payload,json=encryptedPayload,proto3" json:"encrypted_payload,omitempty"`
-	ReceivedAtUnix   int64                  `protobuf:"varint,3,opt,name=received_at_unix,json=receivedAtUnix,proto3" json:"received_at_unix,omitempty"`
-	unknownFields    protoimpl.UnknownFields
-	sizeCache        protoimpl.SizeCache
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	WebhookId string                 `protobuf:"bytes,1,opt,name=webhook_id,json=webhookId,proto3" json:"webhook_id,omitempty"`
+	// Redacted update, encrypted as described by algorithm.
+	EncryptedPayload []byte `protobuf:"bytes,2,opt,name=encrypted_payload,json=encryptedPayload,proto3" json:"encrypted_payload,omitempty"`
+	ReceivedAtUnix   int64  `protobuf:"varint,3,opt,name=received_at_unix,json=receivedAtUnix,proto3" json:"received_at_unix,omitempty"`
+	// Data key encrypted under the key-encryption key; empty when the payload
+	// is encrypted with the key-encryption key directly.
+	EncryptedDataKey []byte `protobuf:"bytes,4,opt,name=encrypted_data_key,json=encryptedDataKey,proto3" json:"encrypted_data_key,omitempty"`
+	// Fingerprint of the key-encryption key.
+	KeyId string `protobuf:"bytes,5,opt,name=key_id,json=keyId,proto3" json:"key_id,omitempty"`
+	// "A256GCM+A256GCMKW" (envelope) or "A256GCM" (direct).
+	Algorithm string `protobuf:"bytes,6,opt,name=algorithm,proto3" json:"algorithm,omitempty"`
+	// Telegram update_id, 0 when the update had none.
+	UpdateId int64 `protobuf:"varint,7,opt,name=update_id,json=updateId,proto3" json:"update_id,omitempty"`
+	// Associated data bound to encrypted_payload:
+	//   0 - none
+	//   1 - "hook.TelegramWebhookPayload/1", then webhook_id, update_id and
+	//       received_at_unix, each as a big-endian uint32 length followed by
+	//       the field (webhook_id as UTF-8, the integers as big-endian int64)
+	AadVersion uint32 `protobuf:"varint,8,opt,name=aad_version,json=aadVersion,proto3" json:"aad_version,omitempty"`
+	// Codec the redacted JSON was compressed with before encryption: "" (none),
+	// "gzip" or "zstd".
+	Compression   string `protobuf:"bytes,9,opt,name=compression,proto3" json:"compression,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *TelegramWebhookPayload) Reset() {
@@ -81,16 +100,65 @@ func (x *TelegramWebhookPayload) GetReceivedAtUnix() int64 {
 	return 0
 }
 
+func (x *TelegramWebhookPayload) GetEncryptedDataKey() []byte {
+	if x != nil {
+		return x.EncryptedDataKey
+	}
+	return nil
+}
+
+func (x *TelegramWebhookPayload) GetKeyId() string {
+	if x != nil {
+		return x.KeyId
+	}
+	return ""
+}
+
+func (x *TelegramWebhookPayload) GetAlgorithm() string {
+	if x != nil {
+		return x.Algorithm
+	}
+	return ""
+}
+
+func (x *TelegramWebhookPayload) GetUpdateId() int64 {
+	if x != nil {
+		return x.UpdateId
+	}
+	return 0
+}
+
+func (x *TelegramWebhookPayload) GetAadVersion() uint32 {
+	if x != nil {
+		return x.AadVersion
+	}
+	return 0
+}
+
+func (x *TelegramWebhookPayload) GetCompression() string {
+	if x != nil {
+		return x.Compression
+	}
+	return ""
+}
+
 var File_proto_payload_proto protoreflect.FileDescriptor
 
 const file_proto_payload_proto_rawDesc = "" +
 	"\n" +
-	"\x13proto/payload.proto\x12\x04hook\"\x8e\x01\n" +
+	"\x13proto/payload.proto\x12\x04hook\"\xd1\x02\n" +
 	"\x16TelegramWebhookPayload\x12\x1d\n" +
 	"\n" +
 	"webhook_id\x18\x01 \x01(\tR\twebhookId\x12+\n" +
 	"\x11encrypted_payload\x18\x02 \x01(\fR\x10encryptedPayload\x12(\n" +
-	"\x10received_at_unix\x18\x03 \x01(\x03R\x0ereceivedAtUnixB\x1bZ\x19murmapp.hook/proto;hookpbb\x06proto3"
+	"\x10received_at_unix\x18\x03 \x01(\x03R\x0ereceivedAtUnix\x12,\n" +
+	"\x12encrypted_data_key\x18\x04 \x01(\fR\x10encryptedDataKey\x12\x15\n" +
+	"\x06key_id\x18\x05 \x01(\tR\x05keyId\x12\x1c\n" +
+	"\talgorithm\x18\x06 \x01(\tR\talgorithm\x12\x1b\n" +
+	"\tupdate_id\x18\a \x01(\x03R\bupdateId\x12\x1f\n" +
+	"\vaad_version\x18\b \x01(\rR\n" +
+	"aadVersion\x12 \n" +
+	"\vcompression\x18\t \x01(\tR\vcompressionB\x1bZ\x19murmapp.hook/proto;hookpbb\x06proto3"
 
 var (
 	file_proto_payload_proto_rawDescOnce sync.Once